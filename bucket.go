@@ -0,0 +1,215 @@
+package main
+
+import (
+	"fmt"
+	"reflect"
+	"slices"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	s3types "github.com/aws/aws-sdk-go-v2/service/s3/types"
+)
+
+// Rules created by init carry these IDs, so running it again updates them
+// in place and leaves every other rule on the bucket alone.
+const (
+	expireRuleID = "s3share-expire"
+	abortRuleID  = "s3share-abort-multipart"
+	tempRuleID   = "s3share-temp"
+	corsRuleID   = "s3share"
+)
+
+// defaultExpireDays is how long init has shares kept under a prefix when
+// --expire-days is not given.
+const defaultExpireDays = 30
+
+// initBucket prepares the bucket for sharing: a lifecycle rule expiring
+// shared objects, others cleaning up after uploads that never finished,
+// and a CORS rule letting browsers fetch shares.
+func (u *Uploader) initBucket([]string) error {
+	if err := u.initLifecycle(); err != nil {
+		return err
+	}
+	return u.initCORS()
+}
+
+func (u *Uploader) initLifecycle() error {
+	out, err := u.getBucketLifecycleConfiguration(
+		&s3.GetBucketLifecycleConfigurationInput{Bucket: &u.Bucket},
+	)
+	if hasErrorCode(err, "NoSuchLifecycleConfiguration") {
+		out, err = &s3.GetBucketLifecycleConfigurationOutput{}, nil
+	} else if err != nil {
+		return fmt.Errorf("error reading lifecycle rules: %w", err)
+	}
+
+	// Rules are scoped to the prefix so that shares under different
+	// prefixes of one bucket may be kept for different lengths of time.
+	prefix := u.keyPrefix()
+	suffix := ""
+	if prefix != "" {
+		suffix = ":" + strings.TrimSuffix(prefix, "/")
+	}
+	filter := &s3types.LifecycleRuleFilter{Prefix: &prefix}
+
+	// Without a prefix the expire rule would cover every object in the
+	// bucket, including those s3share never wrote, so it is only touched
+	// when asked for.
+	days, skipExpire := u.ExpireDays, false
+	if days < 0 && prefix == "" {
+		skipExpire = true
+		u.logf("not expiring objects without a prefix;" +
+			" pass --expire-days to expire the whole bucket")
+	} else if days < 0 {
+		days = defaultExpireDays
+	}
+
+	var expire, abort, temp *s3types.LifecycleRule
+	if days > 0 {
+		expire = &s3types.LifecycleRule{
+			ID:     aws.String(expireRuleID + suffix),
+			Status: s3types.ExpirationStatusEnabled,
+			Filter: filter,
+			Expiration: &s3types.LifecycleExpiration{
+				Days: aws.Int32(int32(days)),
+			},
+		}
+	}
+	if u.AbortDays > 0 {
+		mpu := &s3types.AbortIncompleteMultipartUpload{
+			DaysAfterInitiation: aws.Int32(int32(u.AbortDays)),
+		}
+		abort = &s3types.LifecycleRule{
+			ID:                             aws.String(abortRuleID + suffix),
+			Status:                         s3types.ExpirationStatusEnabled,
+			Filter:                         filter,
+			AbortIncompleteMultipartUpload: mpu,
+		}
+		// Single-pass uploads interrupted before being copied into place
+		// leave their temporary objects behind.
+		temp = &s3types.LifecycleRule{
+			ID:     aws.String(tempRuleID + suffix),
+			Status: s3types.ExpirationStatusEnabled,
+			Filter: &s3types.LifecycleRuleFilter{
+				Prefix: aws.String(prefix + tempPrefix),
+			},
+			Expiration: &s3types.LifecycleExpiration{
+				Days: aws.Int32(int32(u.AbortDays)),
+			},
+		}
+	}
+
+	rules, changed := out.Rules, false
+	for _, r := range []struct {
+		id   string
+		rule *s3types.LifecycleRule
+	}{
+		{expireRuleID, expire},
+		{abortRuleID, abort},
+		{tempRuleID, temp},
+	} {
+		if r.id == expireRuleID && skipExpire {
+			continue
+		}
+		var c bool
+		rules, c = mergeRule(rules, r.id+suffix, r.rule,
+			func(r s3types.LifecycleRule) *string { return r.ID })
+		changed = changed || c
+	}
+	switch {
+	case !changed:
+		u.logf("lifecycle rules of %s are up to date", u.Bucket)
+		return nil
+	case len(rules) == 0:
+		_, err = u.deleteBucketLifecycle(
+			&s3.DeleteBucketLifecycleInput{Bucket: &u.Bucket},
+		)
+	default:
+		minSize := out.TransitionDefaultMinimumObjectSize
+		_, err = u.putBucketLifecycleConfiguration(
+			&s3.PutBucketLifecycleConfigurationInput{
+				Bucket: &u.Bucket,
+				LifecycleConfiguration: &s3types.BucketLifecycleConfiguration{
+					Rules: rules,
+				},
+				TransitionDefaultMinimumObjectSize: minSize,
+			},
+		)
+	}
+	if err != nil {
+		return fmt.Errorf("error updating lifecycle rules: %w", err)
+	}
+	u.logf("updated lifecycle rules of %s", u.Bucket)
+	return nil
+}
+
+func (u *Uploader) initCORS() error {
+	out, err := u.getBucketCors(&s3.GetBucketCorsInput{Bucket: &u.Bucket})
+	if hasErrorCode(err, "NoSuchCORSConfiguration") {
+		out, err = &s3.GetBucketCorsOutput{}, nil
+	} else if err != nil {
+		return fmt.Errorf("error reading CORS rules: %w", err)
+	}
+
+	var rule *s3types.CORSRule
+	if u.CORSOrigin != "" {
+		rule = &s3types.CORSRule{
+			ID:             aws.String(corsRuleID),
+			AllowedMethods: []string{"GET", "HEAD"},
+			AllowedOrigins: strings.Split(u.CORSOrigin, ","),
+			AllowedHeaders: []string{"*"},
+			ExposeHeaders: []string{
+				"Content-Disposition",
+				"Content-Length",
+				"Content-Type",
+				"ETag",
+			},
+			MaxAgeSeconds: aws.Int32(3600),
+		}
+	}
+
+	rules, changed := mergeRule(out.CORSRules, corsRuleID, rule,
+		func(r s3types.CORSRule) *string { return r.ID })
+	switch {
+	case !changed:
+		u.logf("CORS rules of %s are up to date", u.Bucket)
+		return nil
+	case len(rules) == 0:
+		_, err = u.deleteBucketCors(
+			&s3.DeleteBucketCorsInput{Bucket: &u.Bucket},
+		)
+	default:
+		_, err = u.putBucketCors(&s3.PutBucketCorsInput{
+			Bucket:            &u.Bucket,
+			CORSConfiguration: &s3types.CORSConfiguration{CORSRules: rules},
+		})
+	}
+	if err != nil {
+		return fmt.Errorf("error updating CORS rules: %w", err)
+	}
+	u.logf("updated CORS rules of %s", u.Bucket)
+	return nil
+}
+
+// mergeRule replaces the rule with the given ID by rule, adding it if there
+// is none and removing it if rule is nil. It reports whether rules changed.
+func mergeRule[T any](
+	rules []T, id string, rule *T, ruleID func(T) *string,
+) ([]T, bool) {
+	i := slices.IndexFunc(rules, func(r T) bool {
+		return aws.ToString(ruleID(r)) == id
+	})
+	switch {
+	case i < 0 && rule == nil:
+		return rules, false
+	case i < 0:
+		return append(rules, *rule), true
+	case rule == nil:
+		return slices.Delete(rules, i, i+1), true
+	case reflect.DeepEqual(rules[i], *rule):
+		return rules, false
+	}
+	rules[i] = *rule
+	return rules, true
+}
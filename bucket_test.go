@@ -0,0 +1,231 @@
+package main
+
+import (
+	"context"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	s3types "github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/aws/smithy-go"
+	"gotest.tools/v3/assert"
+)
+
+// mockBucketRules makes the client behave like a bucket starting out with
+// the given rules, storing whatever is put.
+func mockBucketRules(
+	c *s3Client,
+	lifecycle []s3types.LifecycleRule,
+	cors []s3types.CORSRule,
+) {
+	c._GetBucketLifecycleConfiguration_Do(func(
+		context.Context,
+		*s3.GetBucketLifecycleConfigurationInput,
+		...func(*s3.Options),
+	) (*s3.GetBucketLifecycleConfigurationOutput, error) {
+		if lifecycle == nil {
+			return nil, &smithy.GenericAPIError{
+				Code: "NoSuchLifecycleConfiguration",
+			}
+		}
+		return &s3.GetBucketLifecycleConfigurationOutput{
+			Rules: lifecycle,
+		}, nil
+	})
+	c._PutBucketLifecycleConfiguration_Do(func(
+		_ context.Context,
+		in *s3.PutBucketLifecycleConfigurationInput,
+		_ ...func(*s3.Options),
+	) (*s3.PutBucketLifecycleConfigurationOutput, error) {
+		lifecycle = in.LifecycleConfiguration.Rules
+		return &s3.PutBucketLifecycleConfigurationOutput{}, nil
+	})
+	c._GetBucketCors_Do(func(
+		context.Context,
+		*s3.GetBucketCorsInput,
+		...func(*s3.Options),
+	) (*s3.GetBucketCorsOutput, error) {
+		if cors == nil {
+			return nil, &smithy.GenericAPIError{
+				Code: "NoSuchCORSConfiguration",
+			}
+		}
+		return &s3.GetBucketCorsOutput{CORSRules: cors}, nil
+	})
+	c._PutBucketCors_Do(func(
+		_ context.Context,
+		in *s3.PutBucketCorsInput,
+		_ ...func(*s3.Options),
+	) (*s3.PutBucketCorsOutput, error) {
+		cors = in.CORSConfiguration.CORSRules
+		return &s3.PutBucketCorsOutput{}, nil
+	})
+}
+
+func TestRunInitFreshBucket(t *testing.T) {
+	r := newTestRun(t)
+	r.Uploader.Args = &[]string{"s3share", "init", "--prefix", "/shares/"}
+	r.Uploader.Client = new(s3Client)
+	mockBucketRules(r.Uploader.Client, nil, nil)
+
+	err := run(r.Uploader)
+
+	assert.NilError(t, err)
+	lc := r.Uploader.Client._PutBucketLifecycleConfiguration_Calls()
+	assert.Equal(t, len(lc), 1)
+	rules := lc[0].Params.LifecycleConfiguration.Rules
+	assert.Equal(t, len(rules), 3)
+	assert.Equal(t, *rules[0].ID, "s3share-expire:shares")
+	assert.Equal(t, *rules[0].Filter.Prefix, "shares/")
+	assert.Equal(t, *rules[0].Expiration.Days, int32(30))
+	assert.Equal(t, *rules[1].ID, "s3share-abort-multipart:shares")
+	assert.Equal(t,
+		*rules[1].AbortIncompleteMultipartUpload.DaysAfterInitiation,
+		int32(7))
+	assert.Equal(t, *rules[2].ID, "s3share-temp:shares")
+	assert.Equal(t, *rules[2].Filter.Prefix, "shares/.s3share-tmp/")
+
+	cors := r.Uploader.Client._PutBucketCors_Calls()
+	assert.Equal(t, len(cors), 1)
+	corsRules := cors[0].Params.CORSConfiguration.CORSRules
+	assert.Equal(t, len(corsRules), 1)
+	assert.DeepEqual(t, corsRules[0].AllowedOrigins, []string{"*"})
+	assert.DeepEqual(t, corsRules[0].AllowedMethods, []string{"GET", "HEAD"})
+}
+
+func TestRunInitWithoutPrefix(t *testing.T) {
+	r := newTestRun(t)
+	r.Uploader.Args = &[]string{"s3share", "init"}
+	r.Uploader.Client = new(s3Client)
+	mockBucketRules(r.Uploader.Client,
+		[]s3types.LifecycleRule{{ID: aws.String(expireRuleID)}}, nil,
+	)
+
+	err := run(r.Uploader)
+
+	assert.NilError(t, err)
+	lc := r.Uploader.Client._PutBucketLifecycleConfiguration_Calls()
+	assert.Equal(t, len(lc), 1)
+	rules := lc[0].Params.LifecycleConfiguration.Rules
+	assert.Equal(t, len(rules), 3)
+	assert.Equal(t, *rules[0].ID, expireRuleID)
+	assert.Assert(t, rules[0].Expiration == nil)
+	assert.Equal(t, *rules[1].ID, abortRuleID)
+	assert.Equal(t, *rules[2].ID, tempRuleID)
+	assert.Equal(t, r.Stderr[0], "not expiring objects without a prefix;"+
+		" pass --expire-days to expire the whole bucket")
+}
+
+func TestRunInitWithoutPrefixExpireDays(t *testing.T) {
+	r := newTestRun(t)
+	r.Uploader.Args = &[]string{"s3share", "init", "--expire-days", "30"}
+	r.Uploader.Client = new(s3Client)
+	mockBucketRules(r.Uploader.Client, nil, nil)
+
+	err := run(r.Uploader)
+
+	assert.NilError(t, err)
+	lc := r.Uploader.Client._PutBucketLifecycleConfiguration_Calls()
+	assert.Equal(t, len(lc), 1)
+	rules := lc[0].Params.LifecycleConfiguration.Rules
+	assert.Equal(t, len(rules), 3)
+	assert.Equal(t, *rules[0].ID, expireRuleID)
+	assert.Equal(t, *rules[0].Filter.Prefix, "")
+	assert.Equal(t, *rules[0].Expiration.Days, int32(30))
+}
+
+func TestInitMergesRules(t *testing.T) {
+	r := newTestRun(t)
+	r.Uploader.Client = new(s3Client)
+	r.Uploader.ExpireDays = 30
+	r.Uploader.CORSOrigin = "https://a.example,https://b.example"
+	other := s3types.LifecycleRule{
+		ID:     aws.String("logs"),
+		Status: s3types.ExpirationStatusEnabled,
+		Filter: &s3types.LifecycleRuleFilter{Prefix: aws.String("logs/")},
+	}
+	mockBucketRules(r.Uploader.Client,
+		[]s3types.LifecycleRule{
+			other,
+			{
+				ID:         aws.String(expireRuleID),
+				Expiration: &s3types.LifecycleExpiration{Days: aws.Int32(1)},
+			},
+		},
+		[]s3types.CORSRule{{ID: aws.String("app")}},
+	)
+
+	err := r.Uploader.initBucket(nil)
+
+	assert.NilError(t, err)
+	lc := r.Uploader.Client._PutBucketLifecycleConfiguration_Calls()
+	rules := lc[0].Params.LifecycleConfiguration.Rules
+	assert.Equal(t, len(rules), 2)
+	assert.Equal(t, rules[0].Filter, other.Filter)
+	assert.Equal(t, *rules[1].ID, expireRuleID)
+	assert.Equal(t, *rules[1].Expiration.Days, int32(30))
+
+	cors := r.Uploader.Client._PutBucketCors_Calls()
+	corsRules := cors[0].Params.CORSConfiguration.CORSRules
+	assert.Equal(t, len(corsRules), 2)
+	assert.Equal(t, *corsRules[0].ID, "app")
+	assert.DeepEqual(t, corsRules[1].AllowedOrigins, []string{
+		"https://a.example", "https://b.example",
+	})
+}
+
+func TestInitIdempotent(t *testing.T) {
+	r := newTestRun(t)
+	r.Uploader.Client = new(s3Client)
+	r.Uploader.ExpireDays = 30
+	r.Uploader.AbortDays = 7
+	r.Uploader.CORSOrigin = "*"
+	mockBucketRules(r.Uploader.Client, nil, nil)
+
+	assert.NilError(t, r.Uploader.initBucket(nil))
+	assert.NilError(t, r.Uploader.initBucket(nil))
+
+	lc := r.Uploader.Client._PutBucketLifecycleConfiguration_Calls()
+	assert.Equal(t, len(lc), 1)
+	assert.Equal(t, len(r.Uploader.Client._PutBucketCors_Calls()), 1)
+	assert.DeepEqual(t, r.Stderr[2:], []string{
+		"lifecycle rules of somebucket are up to date",
+		"CORS rules of somebucket are up to date",
+	})
+}
+
+func TestInitRemovesDisabledRules(t *testing.T) {
+	r := newTestRun(t)
+	r.Uploader.Client = new(s3Client)
+	mockBucketRules(r.Uploader.Client,
+		[]s3types.LifecycleRule{{ID: aws.String(expireRuleID)}},
+		[]s3types.CORSRule{{ID: aws.String(corsRuleID)}},
+	)
+	r.Uploader.Client._DeleteBucketLifecycle_Return(
+		&s3.DeleteBucketLifecycleOutput{}, nil,
+	)
+	r.Uploader.Client._DeleteBucketCors_Return(
+		&s3.DeleteBucketCorsOutput{}, nil,
+	)
+
+	err := r.Uploader.initBucket(nil)
+
+	assert.NilError(t, err)
+	c := r.Uploader.Client
+	assert.Equal(t, len(c._DeleteBucketLifecycle_Calls()), 1)
+	assert.Equal(t, len(c._DeleteBucketCors_Calls()), 1)
+	assert.Equal(t, len(c._PutBucketLifecycleConfiguration_Calls()), 0)
+	assert.Equal(t, len(c._PutBucketCors_Calls()), 0)
+}
+
+func TestInitReadError(t *testing.T) {
+	r := newTestRun(t)
+	r.Uploader.Client = new(s3Client)
+	r.Uploader.Client._GetBucketLifecycleConfiguration_Return(
+		nil, &smithy.GenericAPIError{Code: "AccessDenied"},
+	)
+
+	err := r.Uploader.initBucket(nil)
+
+	assert.ErrorContains(t, err, "error reading lifecycle rules")
+}
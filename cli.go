@@ -0,0 +1,563 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	s3manager "github.com/aws/aws-sdk-go-v2/feature/s3/manager"
+	s3types "github.com/aws/aws-sdk-go-v2/service/s3/types"
+)
+
+var errHelp = errors.New(`s3share [command] [flags] [file...]
+
+Uploads files to an S3 bucket and prints links to share them.
+
+Commands:
+  put   upload files and print their URLs (default)
+  ls    list shared objects
+  rm    delete shared objects by URL, key, hash or local file
+  info  show details about a file or key
+  get   download and verify shared links or keys
+  gc    delete shares uploaded with a --ttl that has passed
+  abort abort multipart uploads that never finished
+  init  add lifecycle and CORS rules for sharing to the bucket
+
+Most flags may also be set in the environment, such as --bucket
+through S3SHARE_BUCKET; each flag's help names its variable. Flags
+take precedence over the environment.
+
+If an expiry is set, objects are uploaded without an ACL and
+presigned URLs valid for that long are returned instead of
+public links. If a base URL is set, such as a CDN in front of the
+bucket, objects are also uploaded without an ACL and linked to
+through it. The base URL may contain a {key} placeholder. With a
+CloudFront key pair, base URL links are signed to expire instead, or
+signed cookies are printed with --cf-cookies.
+
+A file named - is read from standard input. Use --name to set the
+file name it is shared under. Directories are uploaded recursively
+along with a generated index.html, whose URL is printed. With --zip
+or --tar.gz, all files are bundled into one archive named after the
+first file unless --name is given. With --dry-run, files are hashed
+and their URLs printed, but nothing is written to the bucket; what
+would be uploaded is reported instead. With --single-pass, files
+are hashed while being uploaded to a temporary key and then copied
+into place, so they are read once and stdin is not spooled to disk.
+
+Large files are uploaded in parts, which are made larger as needed
+to fit within --max-parts. With --leave-parts, the parts of a failed
+upload are kept; s3share abort lists and deletes them. With --resume,
+the progress of each upload is saved, and sharing the same file again
+after a failure uploads only the parts that are missing.
+
+With --ttl, uploads are tagged with the time after which s3share gc
+may delete them. Sharing a file again extends its time to live.
+Pair gc with a scheduled job to clean up old shares.
+
+Uploads are typed from their extension or first bytes so browsers
+can show them; --inline or --attachment also asks browsers to show
+or save them under their file name. Since a hashed key always holds
+the same content, uploads may be cached for a year unless
+--cache-control says otherwise. With --gzip or --zstd, uploads are
+compressed on the way and browsers decompress them when opened;
+keys still come from the uncompressed content.
+
+With --encrypt, files are encrypted with a new random key before
+they leave the machine, and the key is put in the fragment of the
+link, after #, which browsers never send to a server. Such links
+are opened with s3share get, which downloads and decrypts them. The
+key of an encrypted upload comes from its encrypted bytes, so it
+says nothing about the content, and sharing a file again uploads a
+new copy.
+
+s3share get downloads links, or keys from the bucket, and checks
+their content against the hash in their key as it arrives. A file
+that does not match is not saved and get fails.
+
+Uploads are encrypted with the bucket's default settings unless --sse
+picks SSE-S3 (aes256) or SSE-KMS (kms, with an optional --kms-key-id).
+With --sse-c-key, objects are encrypted with a key of your own, which
+every later command must be given too. S3 only serves such objects
+to requests that carry the key, so presigned links to them must be
+fetched with the SSE-C headers.
+
+Keys are laid out by --key-template, {prefix}/{hash}/{name} by
+default. Templates may also use {hash:N} for the first N characters
+of the hash, {ext}, {user}, {host}, {date} and {random}. Sharing
+the same content again reuses its object only if the template has
+a {hash} and no {random}; ls, rm and get need the same template the
+files were shared with, so set it in S3SHARE_KEY_TEMPLATE. With a
+--prefix, the template must have a {prefix}.
+
+Run s3share init once to have the bucket expire shares under the
+prefix after --expire-days, clean up unfinished uploads and allow
+browsers to fetch shares. Without a prefix, nothing is expired
+unless --expire-days is given, since that would cover the whole
+bucket. Existing rules are kept, and running it again updates only
+the rules it added.
+
+Run s3share [command] -h to list the flags of a command.`)
+
+type command struct {
+	Name  string
+	Usage string
+	Flags func(*Uploader, *flag.FlagSet)
+	Run   func(*Uploader, []string) error
+
+	// NoBucket commands may work without a bucket, and check for one
+	// themselves when they need it.
+	NoBucket bool
+}
+
+var commands = []*command{
+	{
+		Name:  "put",
+		Usage: "s3share [put] [flags] file...",
+		Flags: putFlags,
+		Run:   (*Uploader).put,
+	},
+	{
+		Name:  "ls",
+		Usage: "s3share ls [flags]",
+		Flags: lsFlags,
+		Run:   (*Uploader).ls,
+	},
+	{
+		Name:  "rm",
+		Usage: "s3share rm [flags] url|key|hash|file...",
+		Flags: rmFlags,
+		Run:   (*Uploader).rm,
+	},
+	{
+		Name:  "info",
+		Usage: "s3share info [flags] file|key...",
+		Run:   (*Uploader).info,
+	},
+	{
+		Name:     "get",
+		Usage:    "s3share get [flags] url|key...",
+		Flags:    getFlags,
+		Run:      (*Uploader).get,
+		NoBucket: true,
+	},
+	{
+		Name:  "gc",
+		Usage: "s3share gc [flags]",
+		Flags: gcFlags,
+		Run:   (*Uploader).gc,
+	},
+	{
+		Name:  "abort",
+		Usage: "s3share abort [flags] [key...]",
+		Flags: abortFlags,
+		Run:   (*Uploader).abortUploads,
+	},
+	{
+		Name:  "init",
+		Usage: "s3share init [flags]",
+		Flags: initFlags,
+		Run:   (*Uploader).initBucket,
+	},
+}
+
+// parseCommand picks the command named by the first positional argument,
+// falling back to put so that s3share file... keeps working, and parses its
+// flags. Flags shared by every command may come before the command name.
+func (u *Uploader) parseCommand(args []string) (*command, []string, error) {
+	cmd := commands[0]
+	if i := u.commandIndex(args); i >= 0 {
+		for _, c := range commands {
+			if c.Name == args[i] {
+				cmd, args = c, slices.Concat(args[:i], args[i+1:])
+				break
+			}
+		}
+	}
+
+	if err := u.loadEnv(); err != nil {
+		return nil, nil, err
+	}
+
+	fs := u.flagSet(cmd)
+	args, err := parseFlags(fs, args)
+	if errors.Is(err, flag.ErrHelp) {
+		var b strings.Builder
+		fs.SetOutput(&b)
+		fs.PrintDefaults()
+		return nil, nil, fmt.Errorf(
+			"%w\n\nusage: %s\n\nFlags:\n%s", errHelp, cmd.Usage, b.String(),
+		)
+	} else if err != nil {
+		return nil, nil, err
+	}
+
+	if err := u.checkFlags(); err != nil {
+		return nil, nil, err
+	}
+	if err := u.loadCloudFrontKey(); err != nil {
+		return nil, nil, err
+	}
+	if err := u.loadSSECKey(); err != nil {
+		return nil, nil, err
+	}
+	return cmd, args, nil
+}
+
+// commandIndex returns the index of the first positional argument in args,
+// skipping the flags shared by every command and their values, or -1 if
+// there is none. Flags only some commands have end the search, since
+// whether they take a value depends on the command.
+func (u *Uploader) commandIndex(args []string) int {
+	global := u.flagSet(&command{})
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		if arg == "--" {
+			return -1
+		} else if arg == "-" || !strings.HasPrefix(arg, "-") {
+			return i
+		}
+
+		name := strings.TrimLeft(arg, "-")
+		if strings.Contains(name, "=") {
+			continue
+		}
+		f := global.Lookup(name)
+		if f == nil {
+			return -1
+		}
+		if b, ok := f.Value.(interface{ IsBoolFlag() bool }); !ok ||
+			!b.IsBoolFlag() {
+			i++
+		}
+	}
+	return -1
+}
+
+func (u *Uploader) loadEnv() error {
+	u.Bucket = u.getenv("S3SHARE_BUCKET")
+	u.Prefix = u.getenv("S3SHARE_PREFIX")
+	u.ACL = u.getenv("S3SHARE_ACL")
+	u.Region = u.getenv("S3SHARE_REGION")
+	u.Profile = u.getenv("S3SHARE_PROFILE")
+	u.Endpoint = u.getenv("S3SHARE_ENDPOINT")
+	u.BaseURL = u.getenv("S3SHARE_BASE_URL")
+	u.CFKeyPairID = u.getenv("S3SHARE_CF_KEY_PAIR_ID")
+	u.CFPrivateKey = u.getenv("S3SHARE_CF_PRIVATE_KEY")
+	u.CFSourceIP = u.getenv("S3SHARE_CF_SOURCE_IP")
+	u.StateDir = u.getenv("S3SHARE_STATE_DIR")
+	u.SSE = u.getenv("S3SHARE_SSE")
+	u.KMSKeyID = u.getenv("S3SHARE_KMS_KEY_ID")
+	u.SSECKeyFile = u.getenv("S3SHARE_SSE_C_KEY")
+	u.Compress = u.getenv("S3SHARE_COMPRESS")
+	u.KeyTemplate = u.getenv("S3SHARE_KEY_TEMPLATE")
+
+	u.CacheControl = u.getenv("S3SHARE_CACHE_CONTROL")
+	if u.CacheControl == "" {
+		u.CacheControl = defaultCacheControl
+	}
+
+	for name, b := range map[string]*bool{
+		"S3SHARE_PATH_STYLE":  &u.PathStyle,
+		"S3SHARE_DUALSTACK":   &u.DualStack,
+		"S3SHARE_ENCRYPT":     &u.Encrypt,
+		"S3SHARE_FIPS":        &u.FIPS,
+		"S3SHARE_LEAVE_PARTS": &u.LeaveParts,
+		"S3SHARE_RESUME":      &u.Resume,
+		"S3SHARE_SINGLE_PASS": &u.SinglePass,
+	} {
+		*b = false
+		if v := u.getenv(name); v != "" {
+			var err error
+			if *b, err = strconv.ParseBool(v); err != nil {
+				return fmt.Errorf("bad %s: %w", name, err)
+			}
+		}
+	}
+
+	for _, v := range []struct {
+		name string
+		n    *int
+		def  int
+		err  error
+	}{
+		{"S3SHARE_JOBS", &u.Jobs, 1, errBadJobs},
+		{"S3SHARE_CONCURRENCY", &u.Concurrency,
+			s3manager.DefaultUploadConcurrency, errBadConcurrency},
+		{"S3SHARE_MAX_PARTS", &u.MaxParts,
+			int(s3manager.MaxUploadParts), errBadMaxParts},
+	} {
+		*v.n = v.def
+		if s := u.getenv(v.name); s != "" {
+			n, err := strconv.Atoi(s)
+			if err != nil || n < 1 {
+				return v.err
+			}
+			*v.n = n
+		}
+	}
+
+	u.PartSize = s3manager.DefaultUploadPartSize
+	if v := u.getenv("S3SHARE_PART_SIZE"); v != "" {
+		n, err := parseSize(v)
+		if err != nil {
+			return fmt.Errorf("%w: %w", errBadPartSize, err)
+		}
+		u.PartSize = n
+	}
+
+	u.TTL = 0
+	if v := u.getenv("S3SHARE_TTL"); v != "" {
+		d, err := parseTTL(v)
+		if err != nil {
+			return err
+		}
+		u.TTL = d
+	}
+
+	u.Expires = 0
+	if v := u.getenv("S3SHARE_EXPIRES"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil {
+			return errBadExpires
+		}
+		u.Expires = d
+	}
+	return nil
+}
+
+func (u *Uploader) flagSet(cmd *command) *flag.FlagSet {
+	fs := flag.NewFlagSet(cmd.Name, flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+
+	fs.StringVar(&u.Bucket, "bucket", u.Bucket,
+		"bucket to share from (S3SHARE_BUCKET)")
+	fs.StringVar(&u.Prefix, "prefix", u.Prefix,
+		"key prefix for shared objects (S3SHARE_PREFIX)")
+	fs.DurationVar(&u.Expires, "expires", u.Expires,
+		"return presigned URLs valid for this long (S3SHARE_EXPIRES)")
+	fs.StringVar(&u.Region, "region", u.Region,
+		"AWS region of the bucket (default: detected) (S3SHARE_REGION)")
+	fs.StringVar(&u.Profile, "profile", u.Profile,
+		"AWS shared config profile (S3SHARE_PROFILE)")
+	fs.StringVar(&u.Endpoint, "endpoint", u.Endpoint,
+		"URL of an S3-compatible service to use (S3SHARE_ENDPOINT)")
+	fs.BoolVar(&u.PathStyle, "path-style", u.PathStyle,
+		"put the bucket in the URL path, not the host (S3SHARE_PATH_STYLE)")
+	fs.BoolVar(&u.DualStack, "dualstack", u.DualStack,
+		"use IPv4 and IPv6 dual-stack endpoints (S3SHARE_DUALSTACK)")
+	fs.BoolVar(&u.FIPS, "fips", u.FIPS,
+		"use FIPS 140-2 validated endpoints (S3SHARE_FIPS)")
+	fs.StringVar(&u.KeyTemplate, "key-template", u.KeyTemplate,
+		"layout of keys (default: "+defaultKeyTemplate+")"+
+			" (S3SHARE_KEY_TEMPLATE)")
+	fs.StringVar(&u.BaseURL, "base-url", u.BaseURL,
+		"link through this URL or {key} template instead (S3SHARE_BASE_URL)")
+	fs.StringVar(&u.CFKeyPairID, "cf-key-pair-id", u.CFKeyPairID,
+		"sign links for CloudFront with this key (S3SHARE_CF_KEY_PAIR_ID)")
+	fs.StringVar(&u.CFPrivateKey, "cf-private-key", u.CFPrivateKey,
+		"PEM file of the CloudFront signing key (S3SHARE_CF_PRIVATE_KEY)")
+	fs.StringVar(&u.CFSourceIP, "cf-source-ip", u.CFSourceIP,
+		"only allow CloudFront links from this CIDR (S3SHARE_CF_SOURCE_IP)")
+	fs.BoolVar(&u.CFCookies, "cf-cookies", u.CFCookies,
+		"print CloudFront signed cookies instead of signing the link")
+	fs.StringVar(&u.SSECKeyFile, "sse-c-key", u.SSECKeyFile,
+		"file of the 32-byte SSE-C customer key (S3SHARE_SSE_C_KEY)")
+	fs.BoolVar(&u.Quiet, "quiet", u.Quiet,
+		"suppress non-essential output")
+	fs.BoolVar(&u.JSON, "json", u.JSON,
+		"print results as JSON")
+
+	if cmd.Flags != nil {
+		cmd.Flags(u, fs)
+	}
+	return fs
+}
+
+func putFlags(u *Uploader, fs *flag.FlagSet) {
+	fs.StringVar(&u.Name, "name", u.Name,
+		"file name to share under (default: base name, or stdin for -)")
+	fs.StringVar(&u.ACL, "acl", u.ACL,
+		"canned ACL to upload with, or none (S3SHARE_ACL)")
+	fs.StringVar(&u.SSE, "sse", u.SSE,
+		"encrypt uploads with aes256 or kms (S3SHARE_SSE)")
+	fs.StringVar(&u.KMSKeyID, "kms-key-id", u.KMSKeyID,
+		"KMS key to encrypt with instead of the default (S3SHARE_KMS_KEY_ID)")
+	fs.IntVar(&u.Jobs, "jobs", u.Jobs,
+		"number of files to upload at once (S3SHARE_JOBS)")
+	fs.BoolVar(&u.KeepGoing, "keep-going", u.KeepGoing,
+		"keep uploading after a failure and report all failures at the end")
+	fs.BoolVar(&u.DryRun, "dry-run", u.DryRun,
+		"print URLs and what would be uploaded without writing to the bucket")
+	fs.Var(sizeValue{&u.PartSize}, "part-size",
+		"size of each part of multipart uploads (S3SHARE_PART_SIZE)")
+	fs.IntVar(&u.Concurrency, "concurrency", u.Concurrency,
+		"parts of each file to upload at once (S3SHARE_CONCURRENCY)")
+	fs.IntVar(&u.MaxParts, "max-parts", u.MaxParts,
+		"most parts to split a file into (S3SHARE_MAX_PARTS)")
+	fs.BoolVar(&u.LeaveParts, "leave-parts", u.LeaveParts,
+		"keep the parts of failed uploads for later (S3SHARE_LEAVE_PARTS)")
+	fs.BoolVar(&u.Resume, "resume", u.Resume,
+		"save the progress of large uploads to resume them (S3SHARE_RESUME)")
+	fs.StringVar(&u.StateDir, "state-dir", u.StateDir,
+		"where --resume keeps its state (default: user cache)"+
+			" (S3SHARE_STATE_DIR)")
+	fs.BoolVar(&u.SinglePass, "single-pass", u.SinglePass,
+		"hash while uploading to read files once (S3SHARE_SINGLE_PASS)")
+	fs.Var(ttlValue{&u.TTL}, "ttl",
+		"let gc delete the upload after this long, e.g. 7d (S3SHARE_TTL)")
+	fs.StringVar(&u.CacheControl, "cache-control", u.CacheControl,
+		"Cache-Control header of uploads, or none"+
+			" (S3SHARE_CACHE_CONTROL)")
+	fs.BoolFunc("inline", "have browsers show uploads when opened",
+		func(string) error { u.Disposition = dispositionInline; return nil })
+	fs.BoolFunc("attachment", "have browsers save uploads when opened",
+		func(string) error {
+			u.Disposition = dispositionAttachment
+			return nil
+		})
+	fs.BoolVar(&u.Encrypt, "encrypt", u.Encrypt,
+		"encrypt files before uploading; the key is put in the link"+
+			" (S3SHARE_ENCRYPT)")
+	fs.BoolFunc("gzip", "compress uploads with gzip (S3SHARE_COMPRESS=gzip)",
+		func(string) error { u.Compress = compressGzip; return nil })
+	fs.BoolFunc("zstd", "compress uploads with zstd (S3SHARE_COMPRESS=zstd)",
+		func(string) error { u.Compress = compressZstd; return nil })
+	fs.BoolFunc("zip", "upload all files as a single zip archive",
+		func(string) error { u.Archive = archiveZip; return nil })
+	fs.BoolFunc("tar.gz", "upload all files as a single tar.gz archive",
+		func(string) error { u.Archive = archiveTarGz; return nil })
+}
+
+func lsFlags(u *Uploader, fs *flag.FlagSet) {
+	fs.Func("since", "only list uploads since a time ago, e.g. 7d, or a date",
+		func(s string) (err error) {
+			u.Since, err = u.parseSince(s)
+			return err
+		})
+}
+
+func rmFlags(u *Uploader, fs *flag.FlagSet) {
+	fs.BoolVar(&u.Yes, "yes", u.Yes,
+		"delete everything matched without asking")
+}
+
+func getFlags(u *Uploader, fs *flag.FlagSet) {
+	fs.StringVar(&u.Output, "o", u.Output,
+		"save to this file or directory, or - for standard output")
+}
+
+func gcFlags(u *Uploader, fs *flag.FlagSet) {
+	fs.BoolVar(&u.DryRun, "dry-run", u.DryRun,
+		"list expired objects without deleting them")
+}
+
+func initFlags(u *Uploader, fs *flag.FlagSet) {
+	// Left negative unless given, since the default depends on the prefix.
+	u.ExpireDays = -1
+	fs.Func("expire-days", "delete objects under the prefix after this many"+
+		" days, or 0 to keep (default: 30, or 0 without a prefix)",
+		func(s string) (err error) {
+			if u.ExpireDays, err = strconv.Atoi(s); err != nil {
+				return err
+			} else if u.ExpireDays < 0 {
+				return errBadDays
+			}
+			return nil
+		})
+	fs.IntVar(&u.AbortDays, "abort-days", 7,
+		"abort unfinished multipart uploads after this many days, or 0")
+	fs.StringVar(&u.CORSOrigin, "cors-origin", "*",
+		"comma-separated origins allowed to fetch shares, or empty for none")
+}
+
+func abortFlags(u *Uploader, fs *flag.FlagSet) {
+	fs.BoolVar(&u.DryRun, "dry-run", u.DryRun,
+		"list unfinished uploads without aborting them")
+	fs.DurationVar(&u.OlderThan, "older-than", u.OlderThan,
+		"only abort uploads started at least this long ago")
+}
+
+func (u *Uploader) checkFlags() error {
+	if u.Expires < 0 || (u.Expires > 0 && u.Expires < time.Second) ||
+		(u.presigned() && u.Expires > maxExpires) {
+		return errBadExpires
+	}
+	if u.Jobs < 1 {
+		return errBadJobs
+	}
+	if u.Concurrency < 1 {
+		return errBadConcurrency
+	}
+	if u.MaxParts < 1 || u.MaxParts > int(s3manager.MaxUploadParts) {
+		return errBadMaxParts
+	}
+	if u.PartSize < minPartSize || u.PartSize > maxPartSize {
+		return errBadPartSize
+	}
+	if u.AbortDays < 0 {
+		return errBadDays
+	}
+	if err := u.checkSSE(); err != nil {
+		return err
+	}
+	if err := u.checkCompress(); err != nil {
+		return err
+	}
+	if u.Encrypt && u.Compress != "" {
+		return errEncryptCompress
+	}
+	if u.KeyTemplate != "" {
+		if err := checkKeyTemplate(u.KeyTemplate); err != nil {
+			return err
+		}
+		// ls, rm, gc, abort and init only look under the prefix, so keys
+		// must be made under it too.
+		if u.keyPrefix() != "" &&
+			!strings.Contains(u.KeyTemplate, "{prefix}") {
+			return fmt.Errorf("%w: --prefix needs {prefix} in the template",
+				errBadKeyTemplate)
+		}
+	}
+	if u.Endpoint != "" {
+		if _, err := parseEndpoint(u.Endpoint); err != nil {
+			return err
+		}
+	}
+	if u.BaseURL != "" {
+		if err := checkBaseURL(u.BaseURL); err != nil {
+			return err
+		}
+	}
+	if u.ACL != "" && u.ACL != "none" && !slices.Contains(
+		s3types.ObjectCannedACL("").Values(),
+		s3types.ObjectCannedACL(u.ACL),
+	) {
+		return fmt.Errorf("%w: %s", errBadACL, u.ACL)
+	}
+	return nil
+}
+
+// parseFlags parses fs from args, allowing flags to appear after positional
+// arguments. Everything after a -- terminator is positional.
+func parseFlags(fs *flag.FlagSet, args []string) ([]string, error) {
+	var pos []string
+	for {
+		if err := fs.Parse(args); err != nil {
+			return nil, err
+		}
+		rest := fs.Args()
+		if n := len(args) - len(rest); n > 0 && args[n-1] == "--" {
+			return append(pos, rest...), nil
+		}
+		if len(rest) == 0 {
+			return pos, nil
+		}
+		pos, args = append(pos, rest[0]), rest[1:]
+	}
+}
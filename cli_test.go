@@ -0,0 +1,162 @@
+package main
+
+import (
+	"flag"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"gotest.tools/v3/assert"
+)
+
+func TestParseFlagsInterspersed(t *testing.T) {
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	name := fs.String("name", "", "")
+	quiet := fs.Bool("quiet", false, "")
+
+	args, err := parseFlags(fs, []string{
+		"a", "--name", "x", "-", "--quiet", "b", "--", "--name",
+	})
+
+	assert.NilError(t, err)
+	assert.DeepEqual(t, args, []string{"a", "-", "b", "--name"})
+	assert.Equal(t, *name, "x")
+	assert.Equal(t, *quiet, true)
+}
+
+func TestParseFlagsUnknown(t *testing.T) {
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+
+	_, err := parseFlags(fs, []string{"a", "--nope"})
+
+	assert.ErrorContains(t, err, "nope")
+}
+
+func TestParseCommandDefaultsToPut(t *testing.T) {
+	r := newTestRun(t)
+
+	cmd, args, err := r.Uploader.parseCommand([]string{"file1", "ls"})
+
+	assert.NilError(t, err)
+	assert.Equal(t, cmd.Name, "put")
+	assert.DeepEqual(t, args, []string{"file1", "ls"})
+}
+
+func TestParseCommandSubcommand(t *testing.T) {
+	r := newTestRun(t)
+
+	cmd, args, err := r.Uploader.parseCommand([]string{"rm", "some/key"})
+
+	assert.NilError(t, err)
+	assert.Equal(t, cmd.Name, "rm")
+	assert.DeepEqual(t, args, []string{"some/key"})
+}
+
+func TestParseCommandAfterFlags(t *testing.T) {
+	tests := []struct {
+		args     []string
+		cmd      string
+		wantArgs []string
+	}{
+		{[]string{"--json", "ls"}, "ls", nil},
+		{[]string{"--bucket", "b", "rm", "key"}, "rm", []string{"key"}},
+		{[]string{"--bucket=b", "-quiet", "rm", "key"}, "rm", []string{"key"}},
+		{[]string{"--bucket", "ls", "f"}, "put", []string{"f"}},
+		{[]string{"--name", "ls", "f"}, "put", []string{"f"}},
+		{[]string{"--json", "--", "ls"}, "put", []string{"ls"}},
+		{[]string{"--json", "f", "ls"}, "put", []string{"f", "ls"}},
+	}
+	for _, tt := range tests {
+		t.Run(strings.Join(tt.args, " "), func(t *testing.T) {
+			r := newTestRun(t)
+
+			cmd, args, err := r.Uploader.parseCommand(tt.args)
+
+			assert.NilError(t, err)
+			assert.Equal(t, cmd.Name, tt.cmd)
+			assert.DeepEqual(t, args, tt.wantArgs)
+		})
+	}
+}
+
+func TestParseCommandFlagsOverrideEnv(t *testing.T) {
+	r := newTestRun(t)
+	r.Uploader.Getenv = func(name string) string {
+		return map[string]string{
+			"S3SHARE_BUCKET":  "envbucket",
+			"S3SHARE_PREFIX":  "envprefix",
+			"S3SHARE_EXPIRES": "1h",
+			"S3SHARE_REGION":  "us-west-2",
+		}[name]
+	}
+
+	_, _, err := r.Uploader.parseCommand([]string{
+		"put", "--bucket", "flagbucket", "--expires", "2h", "f",
+	})
+
+	assert.NilError(t, err)
+	assert.Equal(t, r.Uploader.Bucket, "flagbucket")
+	assert.Equal(t, r.Uploader.Prefix, "envprefix")
+	assert.Equal(t, r.Uploader.Expires, 2*time.Hour)
+	assert.Equal(t, r.Uploader.Region, "us-west-2")
+}
+
+func TestParseCommandHelp(t *testing.T) {
+	r := newTestRun(t)
+
+	_, _, err := r.Uploader.parseCommand([]string{"ls", "-h"})
+
+	assert.ErrorIs(t, err, errHelp)
+	assert.ErrorContains(t, err, "s3share ls")
+	assert.ErrorContains(t, err, "-bucket")
+}
+
+func TestParseCommandBadACL(t *testing.T) {
+	r := newTestRun(t)
+
+	_, _, err := r.Uploader.parseCommand([]string{"--acl", "bogus", "f"})
+
+	assert.ErrorIs(t, err, errBadACL)
+}
+
+func TestParseCommandACLPutOnly(t *testing.T) {
+	r := newTestRun(t)
+
+	_, _, err := r.Uploader.parseCommand([]string{"ls", "--acl", "private"})
+
+	assert.ErrorContains(t, err, "acl")
+}
+
+func TestParseCommandEndpoint(t *testing.T) {
+	r := newTestRun(t)
+	r.Uploader.Getenv = func(name string) string {
+		return map[string]string{
+			"S3SHARE_BUCKET":     "somebucket",
+			"S3SHARE_ENDPOINT":   "http://minio.local:9000",
+			"S3SHARE_PATH_STYLE": "true",
+		}[name]
+	}
+
+	_, _, err := r.Uploader.parseCommand([]string{"f"})
+
+	assert.NilError(t, err)
+	assert.Equal(t, r.Uploader.Endpoint, "http://minio.local:9000")
+	assert.Equal(t, r.Uploader.PathStyle, true)
+}
+
+func TestParseCommandBadEndpoint(t *testing.T) {
+	for _, ep := range []string{"minio.local:9000", "ftp://x", "https://"} {
+		t.Run(ep, func(t *testing.T) {
+			r := newTestRun(t)
+
+			_, _, err := r.Uploader.parseCommand([]string{
+				"--endpoint", ep, "f",
+			})
+
+			assert.ErrorIs(t, err, errBadEndpoint)
+		})
+	}
+}
@@ -0,0 +1,200 @@
+package main
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha1"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+var errCloudFrontKey = errors.New(
+	"CloudFront private key must be a PEM encoded RSA key",
+)
+var errCloudFrontConfig = errors.New(
+	"CloudFront signing needs --cf-key-pair-id, --cf-private-key, " +
+		"--base-url and --expires",
+)
+var errCloudFrontIP = errors.New("CloudFront source IP must be a CIDR range")
+
+// cloudfrontEncoding is the URL-safe base64 variant CloudFront expects in
+// policies and signatures.
+var cloudfrontEncoding = strings.NewReplacer("+", "-", "=", "_", "/", "~")
+
+type cloudfrontPolicy struct {
+	Statement []cloudfrontStatement
+}
+
+type cloudfrontStatement struct {
+	Resource  string
+	Condition cloudfrontCondition
+}
+
+type cloudfrontCondition struct {
+	DateLessThan cloudfrontEpoch
+	IpAddress    *cloudfrontSrcIP `json:",omitempty"`
+}
+
+type cloudfrontEpoch struct {
+	EpochTime int64 `json:"AWS:EpochTime"`
+}
+
+type cloudfrontSrcIP struct {
+	SourceIp string `json:"AWS:SourceIp"`
+}
+
+// loadCloudFrontKey reads the private key used to sign CloudFront URLs and
+// cookies, if CloudFront signing is configured.
+func (u *Uploader) loadCloudFrontKey() error {
+	if u.CFKeyPairID == "" && u.CFPrivateKey == "" {
+		return nil
+	}
+	if u.CFKeyPairID == "" || u.CFPrivateKey == "" ||
+		u.BaseURL == "" || u.Expires == 0 {
+		return errCloudFrontConfig
+	}
+	if u.CFSourceIP != "" {
+		if _, _, err := net.ParseCIDR(u.CFSourceIP); err != nil {
+			return fmt.Errorf("%w: %s", errCloudFrontIP, u.CFSourceIP)
+		}
+	}
+
+	b, err := u.readFile(u.CFPrivateKey)
+	if err != nil {
+		return fmt.Errorf("error reading CloudFront private key: %w", err)
+	}
+	if u.CFKey, err = parseRSAKey(b); err != nil {
+		return err
+	}
+	return nil
+}
+
+func parseRSAKey(b []byte) (*rsa.PrivateKey, error) {
+	block, _ := pem.Decode(b)
+	if block == nil {
+		return nil, errCloudFrontKey
+	}
+	if key, err := x509.ParsePKCS1PrivateKey(block.Bytes); err == nil {
+		return key, nil
+	}
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", errCloudFrontKey, err)
+	}
+	rsaKey, ok := key.(*rsa.PrivateKey)
+	if !ok {
+		return nil, errCloudFrontKey
+	}
+	return rsaKey, nil
+}
+
+// cloudfrontUrl signs link for CloudFront. A canned policy keeps the URL
+// short when only an expiry applies; restricting the source IP takes a
+// custom policy, which is carried in the URL.
+func (u *Uploader) cloudfrontUrl(link string) (string, error) {
+	expires := u.now().Add(u.Expires).Unix()
+	policy, err := u.cloudfrontPolicy(link, expires)
+	if err != nil {
+		return "", err
+	}
+	sig, err := u.cloudfrontSign(policy)
+	if err != nil {
+		return "", err
+	}
+
+	sep := "?"
+	if strings.Contains(link, "?") {
+		sep = "&"
+	}
+	if u.CFSourceIP == "" {
+		link += sep + "Expires=" + strconv.FormatInt(expires, 10)
+	} else {
+		link += sep + "Policy=" + cloudfrontEncode(policy)
+	}
+	return link + "&Signature=" + sig + "&Key-Pair-Id=" + u.CFKeyPairID, nil
+}
+
+// cloudfrontCookies returns signed cookies granting access to link and
+// anything next to it, which for a directory share covers every file
+// linked from its index.
+func (u *Uploader) cloudfrontCookies(link string) ([]*http.Cookie, error) {
+	resource := link
+	if i := strings.LastIndex(link, "/"); i >= 0 &&
+		!strings.Contains(link[i:], "?") {
+		resource = link[:i+1] + "*"
+	}
+
+	expires := u.now().Add(u.Expires).Unix()
+	policy, err := u.cloudfrontPolicy(resource, expires)
+	if err != nil {
+		return nil, err
+	}
+	sig, err := u.cloudfrontSign(policy)
+	if err != nil {
+		return nil, err
+	}
+
+	return []*http.Cookie{
+		{Name: "CloudFront-Policy", Value: cloudfrontEncode(policy)},
+		{Name: "CloudFront-Signature", Value: sig},
+		{Name: "CloudFront-Key-Pair-Id", Value: u.CFKeyPairID},
+	}, nil
+}
+
+func (u *Uploader) cloudfrontPolicy(
+	resource string, expires int64,
+) ([]byte, error) {
+	stmt := cloudfrontStatement{
+		Resource: resource,
+		Condition: cloudfrontCondition{
+			DateLessThan: cloudfrontEpoch{EpochTime: expires},
+		},
+	}
+	if u.CFSourceIP != "" {
+		stmt.Condition.IpAddress = &cloudfrontSrcIP{SourceIp: u.CFSourceIP}
+	}
+
+	// CloudFront rebuilds canned policies byte for byte to check their
+	// signature, so the resource must not have its ampersands escaped.
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	err := enc.Encode(cloudfrontPolicy{
+		Statement: []cloudfrontStatement{stmt},
+	})
+	if err != nil {
+		return nil, err
+	}
+	return bytes.TrimSuffix(buf.Bytes(), []byte("\n")), nil
+}
+
+func (u *Uploader) cloudfrontSign(policy []byte) (string, error) {
+	sum := sha1.Sum(policy)
+	sig, err := rsa.SignPKCS1v15(rand.Reader, u.CFKey, crypto.SHA1, sum[:])
+	if err != nil {
+		return "", fmt.Errorf("error signing CloudFront policy: %w", err)
+	}
+	return cloudfrontEncode(sig), nil
+}
+
+func cloudfrontEncode(b []byte) string {
+	return cloudfrontEncoding.Replace(base64.StdEncoding.EncodeToString(b))
+}
+
+func cookieHeader(cookies []*http.Cookie) string {
+	parts := make([]string, len(cookies))
+	for i, c := range cookies {
+		parts[i] = c.String()
+	}
+	return "Cookie: " + strings.Join(parts, "; ")
+}
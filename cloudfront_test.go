@@ -0,0 +1,172 @@
+package main
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha1"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"gotest.tools/v3/assert"
+)
+
+var testCloudFrontKey = sync.OnceValue(func() *rsa.PrivateKey {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		panic(err)
+	}
+	return key
+})
+
+var testNow = time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+
+func newCloudFrontRun(t *testing.T) *testRun {
+	r := newTestRun(t)
+	r.Uploader.BaseURL = "https://files.example.com"
+	r.Uploader.Expires = time.Hour
+	r.Uploader.CFKeyPairID = "K2JCJMDEHXQW5F"
+	r.Uploader.CFPrivateKey = "key.pem"
+	r.Uploader.Now = func() time.Time { return testNow }
+	r.Uploader.ReadFile = func(string) ([]byte, error) {
+		return pem.EncodeToMemory(&pem.Block{
+			Type:  "RSA PRIVATE KEY",
+			Bytes: x509.MarshalPKCS1PrivateKey(testCloudFrontKey()),
+		}), nil
+	}
+	return r
+}
+
+func cloudfrontDecode(t *testing.T, s string) []byte {
+	t.Helper()
+	b, err := base64.StdEncoding.DecodeString(strings.NewReplacer(
+		"-", "+", "_", "=", "~", "/",
+	).Replace(s))
+	assert.NilError(t, err)
+	return b
+}
+
+func verifyCloudFront(t *testing.T, policy, sig string) {
+	t.Helper()
+	sum := sha1.Sum([]byte(policy))
+	err := rsa.VerifyPKCS1v15(
+		&testCloudFrontKey().PublicKey, crypto.SHA1, sum[:],
+		cloudfrontDecode(t, sig),
+	)
+	assert.NilError(t, err)
+}
+
+func TestCloudFrontCannedUrl(t *testing.T) {
+	r := newCloudFrontRun(t)
+	assert.NilError(t, r.Uploader.loadCloudFrontKey())
+
+	link, err := r.Uploader.objectUrl("hash/some file")
+
+	assert.NilError(t, err)
+	u, err := url.Parse(link)
+	assert.NilError(t, err)
+	q := u.Query()
+	assert.Equal(t, u.Scheme+"://"+u.Host+u.EscapedPath(),
+		"https://files.example.com/hash/some%20file")
+	assert.Equal(t, q.Get("Expires"), "1704168245")
+	assert.Equal(t, q.Get("Key-Pair-Id"), "K2JCJMDEHXQW5F")
+	verifyCloudFront(t, `{"Statement":[{"Resource":`+
+		`"https://files.example.com/hash/some%20file",`+
+		`"Condition":{"DateLessThan":{"AWS:EpochTime":1704168245}}}]}`,
+		q.Get("Signature"))
+}
+
+func TestCloudFrontCustomPolicyUrl(t *testing.T) {
+	r := newCloudFrontRun(t)
+	r.Uploader.BaseURL = "https://files.example.com/{key}?v=1"
+	r.Uploader.CFSourceIP = "203.0.113.0/24"
+	assert.NilError(t, r.Uploader.loadCloudFrontKey())
+
+	link, err := r.Uploader.objectUrl("hash/name")
+
+	assert.NilError(t, err)
+	u, err := url.Parse(link)
+	assert.NilError(t, err)
+	q := u.Query()
+	assert.Equal(t, q.Get("v"), "1")
+	assert.Equal(t, q.Get("Expires"), "")
+	policy := string(cloudfrontDecode(t, q.Get("Policy")))
+	assert.Equal(t, policy, `{"Statement":[{"Resource":`+
+		`"https://files.example.com/hash/name?v=1",`+
+		`"Condition":{"DateLessThan":{"AWS:EpochTime":1704168245},`+
+		`"IpAddress":{"AWS:SourceIp":"203.0.113.0/24"}}}]}`)
+	verifyCloudFront(t, policy, q.Get("Signature"))
+}
+
+func TestCloudFrontCookies(t *testing.T) {
+	r := newCloudFrontRun(t)
+	r.Uploader.CFCookies = true
+	assert.NilError(t, r.Uploader.loadCloudFrontKey())
+
+	link, err := r.Uploader.objectUrl("hash/dir/index.html")
+	assert.NilError(t, err)
+	err = r.Uploader.printPut("dir", link)
+
+	assert.NilError(t, err)
+	assert.Equal(t, link, "https://files.example.com/hash/dir/index.html")
+	assert.Equal(t, len(r.Stdout), 2)
+	assert.Equal(t, r.Stdout[0], link)
+	cookies := map[string]string{}
+	for _, c := range strings.Split(
+		strings.TrimPrefix(r.Stdout[1], "Cookie: "), "; ",
+	) {
+		name, value, _ := strings.Cut(c, "=")
+		cookies[name] = value
+	}
+	assert.Equal(t, cookies["CloudFront-Key-Pair-Id"], "K2JCJMDEHXQW5F")
+	policy := string(cloudfrontDecode(t, cookies["CloudFront-Policy"]))
+	assert.Equal(t, policy, `{"Statement":[{"Resource":`+
+		`"https://files.example.com/hash/dir/*",`+
+		`"Condition":{"DateLessThan":{"AWS:EpochTime":1704168245}}}]}`)
+	verifyCloudFront(t, policy, cookies["CloudFront-Signature"])
+}
+
+func TestLoadCloudFrontKeyPKCS8(t *testing.T) {
+	r := newCloudFrontRun(t)
+	r.Uploader.ReadFile = func(string) ([]byte, error) {
+		b, err := x509.MarshalPKCS8PrivateKey(testCloudFrontKey())
+		return pem.EncodeToMemory(&pem.Block{
+			Type:  "PRIVATE KEY",
+			Bytes: b,
+		}), err
+	}
+
+	err := r.Uploader.loadCloudFrontKey()
+
+	assert.NilError(t, err)
+	assert.Assert(t, r.Uploader.CFKey.Equal(testCloudFrontKey()))
+}
+
+func TestLoadCloudFrontKeyErrors(t *testing.T) {
+	t.Run("missing base url", func(t *testing.T) {
+		r := newCloudFrontRun(t)
+		r.Uploader.BaseURL = ""
+
+		assert.ErrorIs(t, r.Uploader.loadCloudFrontKey(), errCloudFrontConfig)
+	})
+	t.Run("bad pem", func(t *testing.T) {
+		r := newCloudFrontRun(t)
+		r.Uploader.ReadFile = func(string) ([]byte, error) {
+			return []byte("not a key"), nil
+		}
+
+		assert.ErrorIs(t, r.Uploader.loadCloudFrontKey(), errCloudFrontKey)
+	})
+	t.Run("bad source ip", func(t *testing.T) {
+		r := newCloudFrontRun(t)
+		r.Uploader.CFSourceIP = "203.0.113.7"
+
+		assert.ErrorIs(t, r.Uploader.loadCloudFrontKey(), errCloudFrontIP)
+	})
+}
@@ -0,0 +1,518 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	s3types "github.com/aws/aws-sdk-go-v2/service/s3/types"
+)
+
+type putResult struct {
+	File    string            `json:"file"`
+	URL     string            `json:"url"`
+	Cookies map[string]string `json:"cookies,omitempty"`
+}
+
+func (u *Uploader) put(args []string) error {
+	if len(args) < 1 {
+		return errHelp
+	}
+
+	// Progress lines of uploads running side by side would overwrite each
+	// other, so progress is only drawn for one at a time.
+	u.Progress = !u.Quiet && (u.Jobs == 1 || len(args) == 1 ||
+		u.Archive != "") && u.isTerminal()
+
+	if u.Archive != "" {
+		url, err := u.uploadArchive(args)
+		if err != nil {
+			return err
+		}
+		return u.printPut(u.archiveName(args[0]), url)
+	}
+
+	var errs []error
+	for i, job := range u.uploadAll(args) {
+		<-job.done
+		switch {
+		case job.err == nil:
+			if err := u.printPut(args[i], job.url); err != nil {
+				return err
+			}
+		case errors.Is(job.err, errSkipped):
+		case !u.KeepGoing:
+			return job.err
+		default:
+			errs = append(errs, fmt.Errorf("%s: %w", args[i], job.err))
+		}
+	}
+
+	if len(errs) > 0 {
+		return errors.Join(append(errs, fmt.Errorf(
+			"%d of %d uploads failed", len(errs), len(args),
+		))...)
+	}
+	return nil
+}
+
+var errSkipped = errors.New("skipped after an earlier failure")
+
+type uploadJob struct {
+	url  string
+	err  error
+	done chan struct{}
+}
+
+// uploadAll uploads paths using up to u.Jobs clones of u at a time. The
+// returned jobs are in the same order as paths and each is closed once
+// its upload has finished. Unless u.KeepGoing is set, the first failure
+// cancels uploads in flight and skips the rest.
+func (u *Uploader) uploadAll(paths []string) []*uploadJob {
+	jobs := make([]*uploadJob, len(paths))
+	for i := range jobs {
+		jobs[i] = &uploadJob{done: make(chan struct{})}
+	}
+
+	ctx, cancel := context.WithCancel(u.Context)
+	next := make(chan int)
+	go func() {
+		for i := range paths {
+			next <- i
+		}
+		close(next)
+	}()
+
+	var wg sync.WaitGroup
+	for range min(max(u.Jobs, 1), len(paths)) {
+		w := u.Clone()
+		w.Context = ctx
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range next {
+				job := jobs[i]
+				job.url, job.err = w.uploadJob(paths[i], cancel)
+				close(job.done)
+			}
+		}()
+	}
+	go func() {
+		wg.Wait()
+		cancel()
+	}()
+
+	return jobs
+}
+
+func (u *Uploader) uploadJob(path string, cancel func()) (string, error) {
+	if u.Context.Err() != nil {
+		return "", errSkipped
+	}
+
+	url, err := u.uploadFile(path)
+	switch {
+	case err == nil:
+		return url, nil
+	case u.Context.Err() != nil && errors.Is(err, context.Canceled):
+		return "", errSkipped
+	case !u.KeepGoing:
+		cancel()
+	}
+	return "", err
+}
+
+func (u *Uploader) printPut(file, url string) error {
+	var cookies []*http.Cookie
+	if u.CFKey != nil && u.CFCookies {
+		var err error
+		if cookies, err = u.cloudfrontCookies(url); err != nil {
+			return err
+		}
+	}
+
+	if u.JSON {
+		res := putResult{File: file, URL: url}
+		for _, c := range cookies {
+			if res.Cookies == nil {
+				res.Cookies = make(map[string]string)
+			}
+			res.Cookies[c.Name] = c.Value
+		}
+		return u.printJSON(res)
+	}
+	if _, err := u.println(url); err != nil {
+		return err
+	}
+	if cookies != nil {
+		_, err := u.println(cookieHeader(cookies))
+		return err
+	}
+	return nil
+}
+
+type objectInfo struct {
+	Key         string     `json:"key"`
+	Hash        string     `json:"hash,omitempty"`
+	Name        string     `json:"name,omitempty"`
+	URL         string     `json:"url,omitempty"`
+	Exists      bool       `json:"exists"`
+	Size        int64      `json:"size,omitempty"`
+	Modified    *time.Time `json:"modified,omitempty"`
+	ContentType string     `json:"contentType,omitempty"`
+}
+
+// ls lists the shared objects under the prefix, uploaded no earlier than
+// --since if given. Objects whose keys were not made by s3share are skipped.
+func (u *Uploader) ls([]string) error {
+	var b strings.Builder
+	tw := tabwriter.NewWriter(&b, 0, 4, 2, ' ', 0)
+	if !u.JSON {
+		_, _ = fmt.Fprintln(tw, "HASH\tNAME\tSIZE\tUPLOADED\tURL")
+	}
+
+	re := u.keyPattern(true, true)
+	err := u.listObjects(u.keyPrefix(), func(obj s3types.Object) error {
+		hash, name, ok := matchKey(re, *obj.Key)
+		if !ok || obj.LastModified != nil && obj.LastModified.Before(u.Since) {
+			return nil
+		}
+		url, err := u.objectUrl(*obj.Key)
+		if err != nil {
+			return err
+		}
+
+		if u.JSON {
+			return u.printJSON(objectInfo{
+				Key:      *obj.Key,
+				Hash:     hash,
+				Name:     name,
+				URL:      url,
+				Exists:   true,
+				Size:     aws.ToInt64(obj.Size),
+				Modified: obj.LastModified,
+			})
+		}
+		var uploaded string
+		if obj.LastModified != nil {
+			uploaded = obj.LastModified.Local().Format("2006-01-02 15:04")
+		}
+		_, err = fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\n",
+			hash[:min(len(hash), 12)], name,
+			formatSize(aws.ToInt64(obj.Size)), uploaded, url)
+		return err
+	})
+	if err != nil || u.JSON {
+		return err
+	}
+
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+	lines := strings.Split(strings.TrimSuffix(b.String(), "\n"), "\n")
+	for _, line := range lines {
+		if _, err := u.println(strings.TrimRight(line, " ")); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// parseSince parses a --since value, either a time ago such as 12h or 7d
+// or a date or time such as 2024-01-02 or 2024-01-02T15:04:05Z.
+func (u *Uploader) parseSince(s string) (time.Time, error) {
+	if d, err := parseTTL(s); err == nil {
+		return u.now().Add(-d), nil
+	}
+	for _, layout := range []string{time.RFC3339, time.DateOnly} {
+		if t, err := time.ParseInLocation(layout, s, time.Local); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("%w: %s", errBadSince, s)
+}
+
+func formatSize(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
+
+// listObjects calls fn for every object whose key starts with prefix, a
+// page at a time.
+func (u *Uploader) listObjects(
+	prefix string, fn func(s3types.Object) error,
+) error {
+	var token *string
+	for {
+		out, err := u.listObjectsV2(&s3.ListObjectsV2Input{
+			Bucket:            &u.Bucket,
+			Prefix:            &prefix,
+			ContinuationToken: token,
+		})
+		if err != nil {
+			return err
+		}
+		for _, obj := range out.Contents {
+			if err := fn(obj); err != nil {
+				return err
+			}
+		}
+		if out.IsTruncated == nil || !*out.IsTruncated {
+			return nil
+		}
+		token = out.NextContinuationToken
+	}
+}
+
+// keyPrefix returns the prefix every shared key starts with, if any.
+func (u *Uploader) keyPrefix() string {
+	prefix := strings.Trim(u.Prefix, "/")
+	if prefix != "" {
+		prefix += "/"
+	}
+	return prefix
+}
+
+var errNoMatch = errors.New("no shared object matches")
+var errAborted = errors.New("aborted: nothing was deleted.")
+
+// rm deletes the objects matching each argument, which may be a link, an
+// object key, a hash as listed by ls, or a local file or directory. When
+// an argument matches more than one object, rm asks before deleting them.
+func (u *Uploader) rm(args []string) error {
+	if len(args) < 1 {
+		return errHelp
+	}
+
+	var keys []string
+	for _, arg := range args {
+		matches, err := u.resolveKeys(arg)
+		if err != nil {
+			return err
+		} else if len(matches) == 0 {
+			return fmt.Errorf("%w: %s", errNoMatch, arg)
+		}
+		if len(matches) > 1 && !u.Yes {
+			ok, err := u.confirm(fmt.Sprintf(
+				"%s matches %d objects. Delete them all?", arg, len(matches),
+			))
+			if err != nil {
+				return err
+			} else if !ok {
+				return errAborted
+			}
+		}
+		for _, key := range matches {
+			if !slices.Contains(keys, key) {
+				keys = append(keys, key)
+			}
+		}
+	}
+
+	for _, key := range keys {
+		_, err := u.deleteObject(&s3.DeleteObjectInput{
+			Bucket: &u.Bucket,
+			Key:    &key,
+		})
+		if err != nil {
+			return fmt.Errorf("error deleting %s: %w", key, err)
+		}
+		u.logf("deleted %s", key)
+	}
+	return nil
+}
+
+// resolveKeys returns the keys of the existing objects arg refers to.
+func (u *Uploader) resolveKeys(arg string) ([]string, error) {
+	var key string
+	switch fi, err := u.stat(arg); {
+	case strings.Contains(arg, "://"):
+		if key, err = u.urlKey(arg); err != nil {
+			return nil, err
+		}
+	case err == nil && fi.IsDir():
+		files, err := u.walkFiles(arg)
+		if err != nil {
+			return nil, err
+		}
+		return u.listKeys(u.dirKey(arg, files) + "/")
+	case err == nil || arg == "-":
+		if key, _, err = u.openKeyed(arg); err != nil {
+			return nil, err
+		}
+	case !strings.Contains(arg, "/"):
+		return u.hashKeys(arg)
+	default:
+		key = arg
+	}
+
+	if ok, err := u.objectExists(key); err != nil || !ok {
+		return nil, err
+	}
+	return []string{key}, nil
+}
+
+// listKeys returns the keys of shared objects starting with prefix.
+func (u *Uploader) listKeys(prefix string) ([]string, error) {
+	return u.filterKeys(prefix, func(string) bool { return true })
+}
+
+// hashKeys returns the keys of shared objects whose hash starts with hash,
+// or is a start of it for templates that shorten hashes.
+func (u *Uploader) hashKeys(hash string) ([]string, error) {
+	prefix := u.keyPrefix()
+	if strings.HasPrefix(u.keyTemplate(), "{prefix}/{hash}/") {
+		prefix += hash
+	}
+	return u.filterKeys(prefix, func(h string) bool {
+		return h != "" &&
+			(strings.HasPrefix(h, hash) || strings.HasPrefix(hash, h))
+	})
+}
+
+func (u *Uploader) filterKeys(
+	prefix string, match func(hash string) bool,
+) ([]string, error) {
+	re := u.keyPattern(true, true)
+	var keys []string
+	err := u.listObjects(prefix, func(obj s3types.Object) error {
+		if hash, _, ok := matchKey(re, *obj.Key); ok && match(hash) {
+			keys = append(keys, *obj.Key)
+		}
+		return nil
+	})
+	return keys, err
+}
+
+// confirm asks question on standard error and reports whether the answer
+// read from standard input was yes.
+func (u *Uploader) confirm(question string) (bool, error) {
+	if _, err := u.eprintln(question + " [y/N]"); err != nil {
+		return false, err
+	}
+	line, err := bufio.NewReader(u.stdin()).ReadString('\n')
+	if err != nil && !errors.Is(err, io.EOF) {
+		return false, err
+	}
+	switch strings.ToLower(strings.TrimSpace(line)) {
+	case "y", "yes":
+		return true, nil
+	}
+	return false, nil
+}
+
+func (u *Uploader) info(args []string) error {
+	if len(args) < 1 {
+		return errHelp
+	}
+
+	for _, arg := range args {
+		info, err := u.objectInfo(arg)
+		if err != nil {
+			return err
+		}
+		if u.JSON {
+			err = u.printJSON(info)
+		} else {
+			err = u.printInfo(info)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// objectInfo describes the object for arg, which is a local file if one
+// exists at that path and an object key otherwise.
+func (u *Uploader) objectInfo(arg string) (*objectInfo, error) {
+	info := &objectInfo{Key: arg}
+	if _, err := u.stat(arg); err == nil || arg == "-" {
+		key, file, err := u.openKeyed(arg)
+		if err != nil {
+			return nil, err
+		}
+		_ = file.Close()
+		info.Key = key
+	}
+
+	out, err := u.headObject(u.headInput(info.Key))
+	if isNotFound(err) {
+		return info, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	info.Exists = true
+	info.Modified = out.LastModified
+	if out.ContentLength != nil {
+		info.Size = *out.ContentLength
+	}
+	if out.ContentType != nil {
+		info.ContentType = *out.ContentType
+	}
+	if info.URL, err = u.objectUrl(info.Key); err != nil {
+		return nil, err
+	}
+	return info, nil
+}
+
+func (u *Uploader) printInfo(info *objectInfo) error {
+	lines := []any{"key:      " + info.Key}
+	if !info.Exists {
+		lines = append(lines, "exists:   false")
+	} else {
+		lines = append(lines,
+			"url:      "+info.URL,
+			fmt.Sprintf("size:     %d", info.Size),
+		)
+		if info.Modified != nil {
+			lines = append(lines,
+				"modified: "+info.Modified.Format(time.RFC3339))
+		}
+		if info.ContentType != "" {
+			lines = append(lines, "type:     "+info.ContentType)
+		}
+	}
+	for _, l := range lines {
+		if _, err := u.println(l); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (u *Uploader) printJSON(v any) error {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	_, err = u.println(string(b))
+	return err
+}
+
+func (u *Uploader) logf(format string, args ...any) {
+	if u.Quiet {
+		return
+	}
+	_, _ = u.eprintln(fmt.Sprintf(format, args...))
+}
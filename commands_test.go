@@ -0,0 +1,476 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io/fs"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	s3types "github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/aws/smithy-go"
+	"gotest.tools/v3/assert"
+)
+
+func TestRunPutJSON(t *testing.T) {
+	r := newTestRun(t)
+	r.Uploader.Args = &[]string{"s3share", "put", "--json", "file1"}
+	r.Uploader.UploadFile = func(string) (string, error) {
+		return "https://example.com/file1", nil
+	}
+
+	err := run(r.Uploader)
+
+	assert.NilError(t, err)
+	assert.DeepEqual(t, r.Stdout, []string{
+		`{"file":"file1","url":"https://example.com/file1"}`,
+	})
+}
+
+func TestRunPutNoFiles(t *testing.T) {
+	r := newTestRun(t)
+	r.Uploader.Args = &[]string{"s3share", "put"}
+
+	err := run(r.Uploader)
+
+	assert.ErrorIs(t, err, errHelp)
+}
+
+var (
+	lsHashA = "M_PXf7Ma7qaZMzw6v2PyyFjL4omBIN0xN2lHGWjh7Ag"
+	lsHashB = "47DEQpj8HBSa-_TImW-5JCeuQeRkm5NMpJWZG3hSuFU"
+	lsOld   = time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	lsNew   = time.Date(2024, 1, 2, 3, 0, 0, 0, time.UTC)
+)
+
+func TestLsPages(t *testing.T) {
+	r := newTestRun(t)
+	r.Uploader.Client = new(s3Client)
+	var tokens []*string
+	r.Uploader.Client._ListObjectsV2_Do(func(
+		_ context.Context,
+		in *s3.ListObjectsV2Input,
+		_ ...func(*s3.Options),
+	) (*s3.ListObjectsV2Output, error) {
+		tokens = append(tokens, in.ContinuationToken)
+		if in.ContinuationToken == nil {
+			return &s3.ListObjectsV2Output{
+				Contents: []s3types.Object{{
+					Key:          aws.String(lsHashA + "/one.txt"),
+					Size:         aws.Int64(1),
+					LastModified: &lsOld,
+				}},
+				IsTruncated:           aws.Bool(true),
+				NextContinuationToken: aws.String("next"),
+			}, nil
+		}
+		return &s3.ListObjectsV2Output{
+			Contents: []s3types.Object{
+				{Key: aws.String("not/ours"), Size: aws.Int64(5)},
+				{
+					Key:          aws.String(lsHashB + "/docs/a b.txt"),
+					Size:         aws.Int64(1536),
+					LastModified: &lsNew,
+				},
+			},
+		}, nil
+	})
+
+	err := r.Uploader.ls(nil)
+
+	assert.NilError(t, err)
+	const url = "https://somebucket.s3.amazonaws.com/"
+	assert.DeepEqual(t, r.Stdout, []string{
+		"HASH          NAME          SIZE     UPLOADED          URL",
+		"M_PXf7Ma7qaZ  one.txt       1 B      " +
+			lsOld.Local().Format("2006-01-02 15:04") + "  " +
+			url + lsHashA + "/one.txt",
+		"47DEQpj8HBSa  docs/a b.txt  1.5 KiB  " +
+			lsNew.Local().Format("2006-01-02 15:04") + "  " +
+			url + lsHashB + "/docs/a%20b.txt",
+	})
+	assert.Equal(t, len(tokens), 2)
+	assert.Equal(t, *tokens[1], "next")
+}
+
+func TestRunLsSinceJSON(t *testing.T) {
+	r := newTestRun(t)
+	r.Uploader.Args = &[]string{
+		"s3share", "ls", "--json", "--prefix", "shares", "--since", "1d",
+	}
+	r.Uploader.Now = func() time.Time { return lsNew.Add(time.Hour) }
+	r.Uploader.Client = new(s3Client)
+	r.Uploader.Client._ListObjectsV2_Return(&s3.ListObjectsV2Output{
+		Contents: []s3types.Object{
+			{
+				Key:          aws.String("shares/" + lsHashA + "/one.txt"),
+				Size:         aws.Int64(1),
+				LastModified: &lsOld,
+			},
+			{
+				Key:          aws.String("shares/" + lsHashB + "/two.txt"),
+				Size:         aws.Int64(2),
+				LastModified: &lsNew,
+			},
+		},
+	}, nil)
+
+	err := run(r.Uploader)
+
+	assert.NilError(t, err)
+	assert.DeepEqual(t, r.Stdout, []string{
+		`{"key":"shares/` + lsHashB + `/two.txt","hash":"` + lsHashB +
+			`","name":"two.txt","url":"https://somebucket.s3.amazonaws.com` +
+			`/shares/` + lsHashB + `/two.txt","exists":true,"size":2,` +
+			`"modified":"2024-01-02T03:00:00Z"}`,
+	})
+	calls := r.Uploader.Client._ListObjectsV2_Calls()
+	assert.Equal(t, *calls[0].Params.Prefix, "shares/")
+}
+
+func TestParseSince(t *testing.T) {
+	r := newTestRun(t)
+	r.Uploader.Now = func() time.Time { return lsNew }
+	u := r.Uploader
+
+	got, err := u.parseSince("3h")
+	assert.NilError(t, err)
+	assert.Equal(t, got, lsNew.Add(-3*time.Hour))
+
+	got, err = u.parseSince("2024-01-01T00:00:00Z")
+	assert.NilError(t, err)
+	assert.Assert(t, got.Equal(lsOld))
+
+	got, err = u.parseSince("2024-01-01")
+	assert.NilError(t, err)
+	assert.Equal(t, got, time.Date(2024, 1, 1, 0, 0, 0, 0, time.Local))
+
+	_, err = u.parseSince("yesterday")
+	assert.ErrorIs(t, err, errBadSince)
+}
+
+func TestFormatSize(t *testing.T) {
+	t.Parallel()
+	for n, want := range map[int64]string{
+		0:           "0 B",
+		1023:        "1023 B",
+		1024:        "1.0 KiB",
+		5 << 20:     "5.0 MiB",
+		3 << 30 / 2: "1.5 GiB",
+	} {
+		assert.Equal(t, formatSize(n), want)
+	}
+}
+
+func TestRmDeletesKeys(t *testing.T) {
+	r := newTestRun(t)
+	r.Uploader.Stat = func(string) (fs.FileInfo, error) {
+		return nil, fs.ErrNotExist
+	}
+	r.Uploader.ObjectExists = func(string) (bool, error) { return true, nil }
+	r.Uploader.Client = new(s3Client)
+	var keys []string
+	r.Uploader.Client._DeleteObject_Do(func(
+		_ context.Context,
+		in *s3.DeleteObjectInput,
+		_ ...func(*s3.Options),
+	) (*s3.DeleteObjectOutput, error) {
+		keys = append(keys, *in.Key)
+		return &s3.DeleteObjectOutput{}, nil
+	})
+
+	err := r.Uploader.rm([]string{"a/one", "b/two"})
+
+	assert.NilError(t, err)
+	assert.DeepEqual(t, keys, []string{"a/one", "b/two"})
+	assert.DeepEqual(t, r.Stderr, []string{"deleted a/one", "deleted b/two"})
+}
+
+func TestRmQuiet(t *testing.T) {
+	r := newTestRun(t)
+	r.Uploader.ObjectExists = func(string) (bool, error) { return true, nil }
+	r.Uploader.Quiet = true
+	r.Uploader.Client = new(s3Client)
+	r.Uploader.Client._DeleteObject_Return(&s3.DeleteObjectOutput{}, nil)
+
+	err := r.Uploader.rm([]string{"a/one"})
+
+	assert.NilError(t, err)
+	assert.Equal(t, len(r.Stderr), 0)
+}
+
+func mockRmObjects(c *s3Client, keys ...string) {
+	c._ListObjectsV2_Do(func(
+		_ context.Context,
+		in *s3.ListObjectsV2Input,
+		_ ...func(*s3.Options),
+	) (*s3.ListObjectsV2Output, error) {
+		out := &s3.ListObjectsV2Output{}
+		for _, key := range keys {
+			if strings.HasPrefix(key, *in.Prefix) {
+				out.Contents = append(out.Contents, s3types.Object{
+					Key: aws.String(key),
+				})
+			}
+		}
+		return out, nil
+	})
+	c._DeleteObject_Return(&s3.DeleteObjectOutput{}, nil)
+}
+
+func rmDeleted(c *s3Client) []string {
+	var keys []string
+	for _, call := range c._DeleteObject_Calls() {
+		keys = append(keys, *call.Params.Key)
+	}
+	return keys
+}
+
+func TestRmURL(t *testing.T) {
+	r := newTestRun(t)
+	r.Uploader.ObjectExists = func(key string) (bool, error) {
+		return key == lsHashA+"/a b.txt", nil
+	}
+	r.Uploader.Client = new(s3Client)
+	mockRmObjects(r.Uploader.Client)
+
+	err := r.Uploader.rm([]string{
+		"https://somebucket.s3.amazonaws.com/" + lsHashA +
+			"/a%20b.txt?X-Amz-Signature=abc",
+	})
+
+	assert.NilError(t, err)
+	assert.DeepEqual(t, rmDeleted(r.Uploader.Client), []string{
+		lsHashA + "/a b.txt",
+	})
+}
+
+func TestRmLocalFile(t *testing.T) {
+	r := newTestRun(t)
+	r.Uploader.ObjectExists = func(string) (bool, error) { return true, nil }
+	r.Uploader.Client = new(s3Client)
+	mockRmObjects(r.Uploader.Client)
+
+	err := r.Uploader.rm([]string{"somefile"})
+
+	assert.NilError(t, err)
+	assert.DeepEqual(t, rmDeleted(r.Uploader.Client), []string{
+		mockFileDataEncoded + "/somefile",
+	})
+}
+
+func TestRmHashConfirm(t *testing.T) {
+	tests := []struct {
+		name    string
+		answer  string
+		yes     bool
+		deleted int
+		err     error
+	}{
+		{name: "yes", answer: "y\n", deleted: 2},
+		{name: "no", answer: "n\n", err: errAborted},
+		{name: "eof", answer: "", err: errAborted},
+		{name: "flag", yes: true, deleted: 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := newTestRun(t)
+			r.Uploader.Stat = func(string) (fs.FileInfo, error) {
+				return nil, fs.ErrNotExist
+			}
+			r.Uploader.Stdin = strings.NewReader(tt.answer)
+			r.Uploader.Yes = tt.yes
+			r.Uploader.Client = new(s3Client)
+			mockRmObjects(r.Uploader.Client,
+				lsHashA+"/dir/index.html",
+				lsHashA+"/dir/a.txt",
+				lsHashB+"/other.txt",
+			)
+
+			err := r.Uploader.rm([]string{"M_PXf7Ma7qaZ"})
+
+			if tt.err != nil {
+				assert.ErrorIs(t, err, tt.err)
+			} else {
+				assert.NilError(t, err)
+			}
+			assert.Equal(t, len(rmDeleted(r.Uploader.Client)), tt.deleted)
+			if !tt.yes {
+				assert.Equal(t, r.Stderr[0],
+					"M_PXf7Ma7qaZ matches 2 objects. Delete them all? [y/N]")
+			}
+		})
+	}
+}
+
+func TestRmNoMatch(t *testing.T) {
+	r := newTestRun(t)
+	r.Uploader.Stat = func(string) (fs.FileInfo, error) {
+		return nil, fs.ErrNotExist
+	}
+	r.Uploader.ObjectExists = func(key string) (bool, error) {
+		return key == lsHashB+"/other.txt", nil
+	}
+	r.Uploader.Client = new(s3Client)
+	mockRmObjects(r.Uploader.Client, lsHashB+"/other.txt")
+
+	err := r.Uploader.rm([]string{lsHashB + "/other.txt", "abc/missing"})
+
+	assert.ErrorIs(t, err, errNoMatch)
+	assert.Equal(t, len(rmDeleted(r.Uploader.Client)), 0)
+}
+
+func TestInfoLocalFile(t *testing.T) {
+	r := newTestRun(t)
+	r.Uploader.Client = new(s3Client)
+	var key string
+	modified := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	r.Uploader.Client._HeadObject_Do(func(
+		_ context.Context,
+		in *s3.HeadObjectInput,
+		_ ...func(*s3.Options),
+	) (*s3.HeadObjectOutput, error) {
+		key = *in.Key
+		return &s3.HeadObjectOutput{
+			ContentLength: aws.Int64(8),
+			LastModified:  &modified,
+		}, nil
+	})
+
+	err := r.Uploader.info([]string{"somefile"})
+
+	assert.NilError(t, err)
+	assert.Equal(t, key, mockFileDataEncoded+"/somefile")
+	assert.DeepEqual(t, r.Stdout, []string{
+		"key:      " + mockFileDataEncoded + "/somefile",
+		"url:      https://somebucket.s3.amazonaws.com/" +
+			mockFileDataEncoded + "/somefile",
+		"size:     8",
+		"modified: 2024-01-02T03:04:05Z",
+	})
+}
+
+func TestInfoMissingKey(t *testing.T) {
+	r := newTestRun(t)
+	r.Uploader.Stat = func(string) (fs.FileInfo, error) {
+		return nil, fs.ErrNotExist
+	}
+	r.Uploader.JSON = true
+	r.Uploader.Client = new(s3Client)
+	r.Uploader.Client._HeadObject_Return(
+		nil, &smithy.GenericAPIError{Code: "NotFound"},
+	)
+
+	err := r.Uploader.info([]string{"some/key"})
+
+	assert.NilError(t, err)
+	assert.DeepEqual(t, r.Stdout, []string{
+		`{"key":"some/key","exists":false}`,
+	})
+}
+
+func TestInfoHeadError(t *testing.T) {
+	r := newTestRun(t)
+	headErr := errors.New("mock error")
+	r.Uploader.Client = new(s3Client)
+	r.Uploader.Client._HeadObject_Return(nil, headErr)
+
+	err := r.Uploader.info([]string{"somefile"})
+
+	assert.ErrorIs(t, err, headErr)
+}
+
+func TestPutJobsOrderedOutput(t *testing.T) {
+	r := newTestRun(t)
+	r.Uploader.Jobs = 3
+	release := map[string]chan struct{}{
+		"a": make(chan struct{}),
+		"b": make(chan struct{}),
+		"c": make(chan struct{}),
+	}
+	var mu sync.Mutex
+	var started []string
+	r.Uploader.UploadFile = func(file string) (string, error) {
+		mu.Lock()
+		started = append(started, file)
+		if len(started) == 3 {
+			close(release["c"])
+		}
+		mu.Unlock()
+		<-release[file]
+		switch file {
+		case "c":
+			close(release["b"])
+		case "b":
+			close(release["a"])
+		}
+		return "https://example.com/" + file, nil
+	}
+
+	err := r.Uploader.put([]string{"a", "b", "c"})
+
+	assert.NilError(t, err)
+	assert.DeepEqual(t, r.Stdout, []string{
+		"https://example.com/a",
+		"https://example.com/b",
+		"https://example.com/c",
+	})
+}
+
+func TestPutFailFast(t *testing.T) {
+	r := newTestRun(t)
+	errUpload := errors.New("mock error")
+	r.Uploader.UploadFile = func(file string) (string, error) {
+		r.UploadFileCalls = append(r.UploadFileCalls, file)
+		if file == "b" {
+			return "", errUpload
+		}
+		return "https://example.com/" + file, nil
+	}
+
+	err := r.Uploader.put([]string{"a", "b", "c"})
+
+	assert.Assert(t, err == errUpload)
+	assert.DeepEqual(t, r.UploadFileCalls, []string{"a", "b"})
+	assert.DeepEqual(t, r.Stdout, []string{"https://example.com/a"})
+}
+
+func TestPutKeepGoing(t *testing.T) {
+	r := newTestRun(t)
+	r.Uploader.Jobs = 2
+	r.Uploader.KeepGoing = true
+	errB := errors.New("error b")
+	errD := errors.New("error d")
+	r.Uploader.UploadFile = func(file string) (string, error) {
+		switch file {
+		case "b":
+			return "", errB
+		case "d":
+			return "", errD
+		}
+		return "https://example.com/" + file, nil
+	}
+
+	err := r.Uploader.put([]string{"a", "b", "c", "d"})
+
+	assert.ErrorIs(t, err, errB)
+	assert.ErrorIs(t, err, errD)
+	assert.Error(t, err, "b: error b\nd: error d\n2 of 4 uploads failed")
+	assert.DeepEqual(t, r.Stdout, []string{
+		"https://example.com/a",
+		"https://example.com/c",
+	})
+}
+
+func TestRunBadJobs(t *testing.T) {
+	r := newTestRun(t)
+	r.Uploader.Args = &[]string{"s3share", "--jobs", "0", "file"}
+
+	err := run(r.Uploader)
+
+	assert.ErrorIs(t, err, errBadJobs)
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/klauspost/compress/zstd"
+)
+
+var errBadCompress = errors.New("compression must be gzip or zstd")
+
+const (
+	compressGzip = "gzip"
+	compressZstd = "zstd"
+)
+
+func (u *Uploader) checkCompress() error {
+	switch u.Compress {
+	case "", compressGzip, compressZstd:
+		return nil
+	default:
+		return fmt.Errorf("%w: %s", errBadCompress, u.Compress)
+	}
+}
+
+// compress makes the body of in compressed as it is read and sets its
+// Content-Encoding, so that browsers decompress it on the fly. Keys are
+// still derived from the uncompressed bytes. The returned function stops
+// the compression early if the upload ends before reading all of it.
+func (u *Uploader) compress(in *s3.PutObjectInput) func() {
+	if u.Compress == "" {
+		return func() {}
+	}
+
+	pr, pw := io.Pipe()
+	body := in.Body
+	go func() {
+		var w io.WriteCloser
+		switch u.Compress {
+		case compressZstd:
+			zw, err := zstd.NewWriter(pw)
+			if err != nil {
+				pw.CloseWithError(err)
+				return
+			}
+			w = zw
+		default:
+			w = gzip.NewWriter(pw)
+		}
+		_, err := io.Copy(w, body)
+		if cerr := w.Close(); err == nil {
+			err = cerr
+		}
+		pw.CloseWithError(err)
+	}()
+
+	in.Body = pr
+	in.ContentEncoding = &u.Compress
+	// The compressed size is not known until the end.
+	in.ContentLength = nil
+	return func() { _ = pr.Close() }
+}
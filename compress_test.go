@@ -0,0 +1,151 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	s3manager "github.com/aws/aws-sdk-go-v2/feature/s3/manager"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	s3types "github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/klauspost/compress/zstd"
+	"gotest.tools/v3/assert"
+)
+
+func newCompressRun(t *testing.T, args ...string) (*testRun, *[]byte) {
+	r := newTestRun(t)
+	r.Uploader.UploadFile = nil
+	r.Uploader.Args = &[]string{"s3share"}
+	*r.Uploader.Args = append(*r.Uploader.Args, args...)
+	var body []byte
+	r.Uploader.PutObject = func(
+		in *s3.PutObjectInput,
+	) (*s3manager.UploadOutput, error) {
+		r.PutObjectCalls = append(r.PutObjectCalls, in)
+		var err error
+		body, err = io.ReadAll(in.Body)
+		return &s3manager.UploadOutput{}, err
+	}
+	return r, &body
+}
+
+func TestRunCompress(t *testing.T) {
+	tests := []struct {
+		flag   string
+		decode func(io.Reader) (io.Reader, error)
+	}{
+		{"--gzip", func(r io.Reader) (io.Reader, error) {
+			return gzip.NewReader(r)
+		}},
+		{"--zstd", func(r io.Reader) (io.Reader, error) {
+			return zstd.NewReader(r)
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.flag, func(t *testing.T) {
+			r, body := newCompressRun(t, tt.flag, "somefile")
+
+			err := run(r.Uploader)
+
+			assert.NilError(t, err)
+			in := r.PutObjectCalls[0]
+			assert.Equal(t, *in.Key, mockFileDataEncoded+"/somefile")
+			assert.Equal(t, *in.ContentEncoding, tt.flag[2:])
+			zr, err := tt.decode(bytes.NewReader(*body))
+			assert.NilError(t, err)
+			b, err := io.ReadAll(zr)
+			assert.NilError(t, err)
+			assert.DeepEqual(t, b, mockFileData)
+		})
+	}
+}
+
+func TestRunCompressSinglePass(t *testing.T) {
+	r, body := newCompressRun(t, "--gzip", "--single-pass", "somefile")
+	r.Uploader.Client = new(s3Client)
+	r.Uploader.Client._HeadObject_Return(&s3.HeadObjectOutput{
+		ContentLength: aws.Int64(30),
+	}, nil)
+	r.Uploader.Client._CopyObject_Return(&s3.CopyObjectOutput{}, nil)
+	r.Uploader.Client._DeleteObject_Return(&s3.DeleteObjectOutput{}, nil)
+
+	err := run(r.Uploader)
+
+	assert.NilError(t, err)
+	in := r.PutObjectCalls[0]
+	assert.Assert(t, in.ContentLength == nil)
+	assert.Equal(t, *in.ContentEncoding, "gzip")
+	heads := r.Uploader.Client._HeadObject_Calls()
+	assert.Equal(t, len(heads), 1)
+	assert.Equal(t, *heads[0].Params.Key, *in.Key)
+	copies := r.Uploader.Client._CopyObject_Calls()
+	assert.Equal(t, *copies[0].Params.Key, mockFileDataEncoded+"/somefile")
+	zr, err := gzip.NewReader(bytes.NewReader(*body))
+	assert.NilError(t, err)
+	b, err := io.ReadAll(zr)
+	assert.NilError(t, err)
+	assert.DeepEqual(t, b, mockFileData)
+}
+
+// The stored size of a compressed single-pass upload, not the size read,
+// decides how it is copied into place.
+func TestRunCompressSinglePassLarge(t *testing.T) {
+	r, _ := newCompressRun(t, "--zstd", "--single-pass", "somefile")
+	c := new(s3Client)
+	r.Uploader.Client = c
+	c._HeadObject_Return(&s3.HeadObjectOutput{
+		ContentLength: aws.Int64(maxCopySize + 1),
+	}, nil)
+	c._CreateMultipartUpload_Return(&s3.CreateMultipartUploadOutput{
+		UploadId: aws.String("upload"),
+	}, nil)
+	c._UploadPartCopy_Return(&s3.UploadPartCopyOutput{
+		CopyPartResult: &s3types.CopyPartResult{},
+	}, nil)
+	c._CompleteMultipartUpload_Return(
+		&s3.CompleteMultipartUploadOutput{}, nil,
+	)
+	c._DeleteObject_Return(&s3.DeleteObjectOutput{}, nil)
+
+	err := run(r.Uploader)
+
+	assert.NilError(t, err)
+	assert.Equal(t, len(c._CopyObject_Calls()), 0)
+	copies := c._UploadPartCopy_Calls()
+	assert.Equal(t, len(copies), 11)
+	assert.Equal(t, *copies[10].Params.CopySourceRange,
+		"bytes=5368709120-5368709120")
+}
+
+func TestCompressStopsEarly(t *testing.T) {
+	r := newTestRun(t)
+	r.Uploader.Compress = compressGzip
+	in := &s3.PutObjectInput{Body: bytes.NewReader(make([]byte, 1<<20))}
+
+	stop := r.Uploader.compress(in)
+	_, err := in.Body.Read(make([]byte, 10))
+	stop()
+
+	assert.NilError(t, err)
+	_, err = io.ReadAll(in.Body)
+	assert.ErrorIs(t, err, io.ErrClosedPipe)
+}
+
+func TestRunBadCompress(t *testing.T) {
+	r := newTestRun(t)
+	r.Uploader.Getenv = func(name string) string {
+		switch name {
+		case "S3SHARE_BUCKET":
+			return "somebucket"
+		case "S3SHARE_COMPRESS":
+			return "brotli"
+		}
+		return ""
+	}
+
+	err := run(r.Uploader)
+
+	assert.ErrorIs(t, err, errBadCompress)
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"bufio"
+	"io"
+	"mime"
+	"net/http"
+	"path"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+// sniffLen is how much of a file http.DetectContentType looks at.
+const sniffLen = 512
+
+// defaultCacheControl lets browsers and CDNs keep shares for good, since
+// the content behind a hashed key never changes.
+const defaultCacheControl = "public, max-age=31536000, immutable"
+
+const (
+	dispositionInline     = "inline"
+	dispositionAttachment = "attachment"
+)
+
+// contentHeaders sets the headers browsers need to show or save an upload
+// shared as name. The type comes from the extension of name, or failing
+// that from the first bytes of the body.
+func (u *Uploader) contentHeaders(in *s3.PutObjectInput, name string) error {
+	if u.CacheControl != "" && u.CacheControl != "none" {
+		in.CacheControl = &u.CacheControl
+	}
+	if u.Disposition != "" {
+		v := mime.FormatMediaType(u.Disposition, map[string]string{
+			"filename": name,
+		})
+		in.ContentDisposition = &v
+	}
+
+	if u.Encrypt {
+		// The type of the plaintext is nobody else's business, and
+		// browsers could not show ciphertext anyway.
+		in.ContentType = aws.String("application/octet-stream")
+		return nil
+	}
+	if t := mime.TypeByExtension(path.Ext(name)); t != "" {
+		in.ContentType = &t
+		return nil
+	}
+	head, err := u.sniff(in)
+	if err != nil {
+		return err
+	}
+	t := http.DetectContentType(head)
+	in.ContentType = &t
+	return nil
+}
+
+// sniff returns the first bytes of the body of in without consuming them,
+// rewinding seekable bodies and buffering others.
+func (u *Uploader) sniff(in *s3.PutObjectInput) ([]byte, error) {
+	if s, ok := in.Body.(io.ReadSeeker); ok {
+		cur, err := s.Seek(0, io.SeekCurrent)
+		if err != nil {
+			return nil, err
+		}
+		head := make([]byte, sniffLen)
+		n, err := io.ReadFull(s, head)
+		if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+			return nil, err
+		}
+		if _, err := s.Seek(cur, io.SeekStart); err != nil {
+			return nil, err
+		}
+		return head[:n], nil
+	}
+
+	br := bufio.NewReaderSize(in.Body, sniffLen)
+	in.Body = br
+	head, err := br.Peek(sniffLen)
+	if err != nil && err != io.EOF {
+		return nil, err
+	}
+	return head, nil
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"strings"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	v4 "github.com/aws/aws-sdk-go-v2/aws/signer/v4"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"gotest.tools/v3/assert"
+)
+
+func TestContentHeaders(t *testing.T) {
+	tests := []struct {
+		name, body, want string
+	}{
+		{"report.html", "", "text/html; charset=utf-8"},
+		{"photo.png", "", "image/png"},
+		{"README", "plain text", "text/plain; charset=utf-8"},
+		{"noext", "\x89PNG\r\n\x1a\n", "image/png"},
+		{"stdin", "\x00\x01\x02", "application/octet-stream"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := newTestRun(t)
+			body := strings.NewReader(tt.body)
+			in := &s3.PutObjectInput{Body: body}
+
+			err := r.Uploader.contentHeaders(in, tt.name)
+
+			assert.NilError(t, err)
+			assert.Equal(t, *in.ContentType, tt.want)
+			assert.Assert(t, in.Body == body)
+			b, err := io.ReadAll(in.Body)
+			assert.NilError(t, err)
+			assert.Equal(t, string(b), tt.body)
+		})
+	}
+}
+
+func TestContentHeadersStream(t *testing.T) {
+	r := newTestRun(t)
+	data := bytes.Repeat([]byte("line of a log\n"), 100)
+	in := &s3.PutObjectInput{Body: io.MultiReader(bytes.NewReader(data))}
+
+	err := r.Uploader.contentHeaders(in, "stdin")
+
+	assert.NilError(t, err)
+	assert.Equal(t, *in.ContentType, "text/plain; charset=utf-8")
+	b, err := io.ReadAll(in.Body)
+	assert.NilError(t, err)
+	assert.DeepEqual(t, b, data)
+}
+
+func TestContentHeadersDisposition(t *testing.T) {
+	r := newTestRun(t)
+	r.Uploader.Disposition = dispositionAttachment
+	r.Uploader.CacheControl = "none"
+	in := &s3.PutObjectInput{Body: strings.NewReader("")}
+
+	err := r.Uploader.contentHeaders(in, "résumé final.pdf")
+
+	assert.NilError(t, err)
+	assert.Equal(t, *in.ContentDisposition,
+		"attachment; filename*=utf-8''r%C3%A9sum%C3%A9%20final.pdf")
+	assert.Assert(t, in.CacheControl == nil)
+}
+
+func TestRunContentHeaders(t *testing.T) {
+	r := newTestRun(t)
+	r.Uploader.UploadFile = nil
+	r.Uploader.Args = &[]string{"s3share", "--inline", "report.html"}
+
+	err := run(r.Uploader)
+
+	assert.NilError(t, err)
+	in := r.PutObjectCalls[0]
+	assert.Equal(t, *in.ContentType, "text/html; charset=utf-8")
+	assert.Equal(t, *in.ContentDisposition,
+		`inline; filename=report.html`)
+	assert.Equal(t, *in.CacheControl, defaultCacheControl)
+}
+
+func TestUploadDirPresignedIndex(t *testing.T) {
+	r := newDirRun(t, fstest.MapFS{"d/a.txt": {Data: []byte("a")}})
+	r.Uploader.Expires = time.Hour
+	r.Uploader.PresignGetObject = func(
+		*s3.GetObjectInput,
+	) (*v4.PresignedHTTPRequest, error) {
+		return &v4.PresignedHTTPRequest{URL: "https://presigned"}, nil
+	}
+	r.Uploader.CacheControl = defaultCacheControl
+	r.Uploader.Disposition = dispositionAttachment
+
+	_, err := r.Uploader.uploadFile("d")
+
+	assert.NilError(t, err)
+	file, index := r.PutObjectCalls[0], r.PutObjectCalls[1]
+	assert.Equal(t, *file.CacheControl, defaultCacheControl)
+	assert.Equal(t, *file.ContentDisposition, "attachment; filename=a.txt")
+	assert.Equal(t, *index.CacheControl, "no-cache")
+	assert.Assert(t, index.ContentDisposition == nil)
+}
@@ -0,0 +1,238 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+)
+
+var errEncryptDir = errors.New(
+	"directories cannot be encrypted; use --zip or --tar.gz",
+)
+var errEncryptCompress = errors.New(
+	"--encrypt cannot be used with --gzip or --zstd",
+)
+var errDecrypt = errors.New("cannot decrypt: wrong key or damaged file")
+var errBadFragmentKey = errors.New("URL has a bad decryption key")
+
+// encryptMagic starts every encrypted object, naming the format version.
+var encryptMagic = []byte("s3share\x01")
+
+// chunkSize is how much plaintext each sealed chunk of an encrypted object
+// holds. Chunks let files of any size be encrypted and checked as they
+// stream, rather than only once they have been read in full.
+const chunkSize = 64 << 10
+
+// keyFragment is the URL fragment parameter carrying the decryption key.
+// Browsers never send fragments to servers, so neither S3 nor a CDN sees
+// the key.
+const keyFragment = "k="
+
+// uploadEncrypted encrypts path with a new random key and uploads it. The
+// key is derived from the hash of the encrypted bytes, which reveals
+// nothing about the plaintext, and the returned URL carries the decryption
+// key in its fragment.
+func (u *Uploader) uploadEncrypted(path string) (string, error) {
+	if path == "-" {
+		return u.encryptUpload(u.name(path), u.stdin(), 0)
+	}
+
+	fi, err := u.stat(path)
+	if err != nil {
+		return "", fmt.Errorf(
+			"file does not exist or cannot be read: %s",
+			path,
+		)
+	}
+	file, err := u.openFile(path)
+	if err != nil {
+		return "", err
+	}
+	defer func() { _ = file.Close() }()
+	return u.encryptUpload(u.name(path), file, fi.Size())
+}
+
+// encryptUpload spools the encryption of r to a temporary file and uploads
+// it as name.
+func (u *Uploader) encryptUpload(
+	name string, r io.Reader, size int64,
+) (string, error) {
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		return "", err
+	}
+
+	file, err := u.createTemp()
+	if err != nil {
+		return "", fmt.Errorf("error encrypting: %w", err)
+	}
+	defer func() { _ = file.Close() }()
+
+	p := u.newProgress("encrypting "+name, size)
+	sum := sha256.New()
+	err = encrypt(io.MultiWriter(file, sum), p.reader(r), key)
+	p.finish()
+	if err != nil {
+		return "", fmt.Errorf("error encrypting: %w", err)
+	}
+
+	url, err := u.uploadKeyed(u.objectKey(sum.Sum(nil), name), name, file)
+	if err != nil {
+		return "", err
+	}
+	return url + "#" + keyFragment +
+		base64.RawURLEncoding.EncodeToString(key), nil
+}
+
+// encrypt writes r to w sealed with AES-GCM under key, in chunks whose
+// nonces count up from zero. The last chunk is marked in its nonce so that
+// a truncated object fails to decrypt.
+func encrypt(w io.Writer, r io.Reader, key []byte) error {
+	aead, err := newAEAD(key)
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(encryptMagic); err != nil {
+		return err
+	}
+
+	br := bufio.NewReaderSize(r, chunkSize)
+	buf := make([]byte, chunkSize)
+	sealed := make([]byte, 0, chunkSize+aead.Overhead())
+	for n := uint64(0); ; n++ {
+		m, err := io.ReadFull(br, buf)
+		if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+			return err
+		}
+		last := m < chunkSize
+		if !last {
+			if _, err := br.Peek(1); err == io.EOF {
+				last = true
+			} else if err != nil {
+				return err
+			}
+		}
+		sealed = aead.Seal(sealed[:0], chunkNonce(n, last), buf[:m], nil)
+		if _, err := w.Write(sealed); err != nil {
+			return err
+		}
+		if last {
+			return nil
+		}
+	}
+}
+
+// decryptReader reads the plaintext of an object written by encrypt.
+type decryptReader struct {
+	r      *bufio.Reader
+	aead   cipher.AEAD
+	n      uint64
+	buf    []byte
+	out    []byte
+	done   bool
+	header bool
+}
+
+func newDecryptReader(r io.Reader, key []byte) (*decryptReader, error) {
+	aead, err := newAEAD(key)
+	if err != nil {
+		return nil, err
+	}
+	return &decryptReader{
+		r:    bufio.NewReaderSize(r, chunkSize+aead.Overhead()),
+		aead: aead,
+		buf:  make([]byte, chunkSize+aead.Overhead()),
+	}, nil
+}
+
+func (d *decryptReader) Read(p []byte) (int, error) {
+	for len(d.out) == 0 {
+		if d.done {
+			return 0, io.EOF
+		}
+		if err := d.next(); err != nil {
+			return 0, err
+		}
+	}
+	n := copy(p, d.out)
+	d.out = d.out[n:]
+	return n, nil
+}
+
+func (d *decryptReader) next() error {
+	if !d.header {
+		magic := make([]byte, len(encryptMagic))
+		_, err := io.ReadFull(d.r, magic)
+		if err == io.EOF || err == io.ErrUnexpectedEOF ||
+			(err == nil && !bytes.Equal(magic, encryptMagic)) {
+			return errDecrypt
+		} else if err != nil {
+			return err
+		}
+		d.header = true
+	}
+
+	m, err := io.ReadFull(d.r, d.buf)
+	if err == io.EOF {
+		// The last chunk never came.
+		return errDecrypt
+	} else if err != nil && err != io.ErrUnexpectedEOF {
+		return err
+	}
+	last := m < len(d.buf)
+	if !last {
+		if _, err := d.r.Peek(1); err == io.EOF {
+			last = true
+		} else if err != nil {
+			return err
+		}
+	}
+
+	d.out, err = d.aead.Open(d.buf[:0], chunkNonce(d.n, last), d.buf[:m], nil)
+	if err != nil {
+		return errDecrypt
+	}
+	d.n++
+	d.done = last
+	return nil
+}
+
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+func chunkNonce(n uint64, last bool) []byte {
+	nonce := make([]byte, 12)
+	binary.BigEndian.PutUint64(nonce[4:], n)
+	if last {
+		nonce[0] = 1
+	}
+	return nonce
+}
+
+// fragmentKey returns the decryption key carried in the fragment of a URL,
+// or nil if there is none.
+func fragmentKey(fragment string) ([]byte, error) {
+	v, ok := strings.CutPrefix(fragment, keyFragment)
+	if !ok {
+		return nil, nil
+	}
+	key, err := base64.RawURLEncoding.DecodeString(v)
+	if err != nil || len(key) != 32 {
+		return nil, errBadFragmentKey
+	}
+	return key, nil
+}
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"testing/fstest"
+	"testing/iotest"
+
+	s3manager "github.com/aws/aws-sdk-go-v2/feature/s3/manager"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"gotest.tools/v3/assert"
+)
+
+var testEncryptKey = bytes.Repeat([]byte{1}, 32)
+
+func encryptBytes(t *testing.T, b []byte) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	assert.NilError(t, encrypt(&buf, bytes.NewReader(b), testEncryptKey))
+	return buf.Bytes()
+}
+
+func decryptBytes(b, key []byte) ([]byte, error) {
+	d, err := newDecryptReader(bytes.NewReader(b), key)
+	if err != nil {
+		return nil, err
+	}
+	return io.ReadAll(d)
+}
+
+func TestEncryptRoundTrip(t *testing.T) {
+	for _, size := range []int{
+		0, 1, chunkSize - 1, chunkSize, chunkSize + 1, 3 * chunkSize,
+	} {
+		plain := bytes.Repeat([]byte{'x'}, size)
+
+		sealed := encryptBytes(t, plain)
+
+		chunks := max(1, (size+chunkSize-1)/chunkSize)
+		assert.Equal(t, len(sealed), len(encryptMagic)+size+16*chunks)
+		got, err := decryptBytes(sealed, testEncryptKey)
+		assert.NilError(t, err)
+		assert.DeepEqual(t, got, plain)
+	}
+}
+
+func TestDecryptErrors(t *testing.T) {
+	sealed := encryptBytes(t, bytes.Repeat([]byte{'x'}, 2*chunkSize+10))
+	chunk := chunkSize + 16
+	flipped := bytes.Clone(sealed)
+	flipped[len(flipped)-1] ^= 1
+
+	tests := []struct {
+		name string
+		b    []byte
+		key  []byte
+	}{
+		{"wrong key", sealed, bytes.Repeat([]byte{2}, 32)},
+		{"flipped bit", flipped, testEncryptKey},
+		{"truncated at chunk", sealed[:len(encryptMagic)+chunk],
+			testEncryptKey},
+		{"no chunks", sealed[:len(encryptMagic)], testEncryptKey},
+		{"not encrypted", []byte("plain"), testEncryptKey},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := decryptBytes(tt.b, tt.key)
+
+			assert.ErrorIs(t, err, errDecrypt)
+		})
+	}
+}
+
+func TestRunEncrypt(t *testing.T) {
+	r := newTestRun(t)
+	r.Uploader.UploadFile = nil
+	r.Uploader.Args = &[]string{"s3share", "--encrypt", "somefile"}
+	var body []byte
+	r.Uploader.PutObject = func(
+		in *s3.PutObjectInput,
+	) (*s3manager.UploadOutput, error) {
+		r.PutObjectCalls = append(r.PutObjectCalls, in)
+		var err error
+		body, err = io.ReadAll(in.Body)
+		return &s3manager.UploadOutput{}, err
+	}
+
+	err := run(r.Uploader)
+
+	assert.NilError(t, err)
+	in := r.PutObjectCalls[0]
+	assert.Equal(t, *in.ContentType, "application/octet-stream")
+	assert.Assert(t, strings.HasSuffix(*in.Key, "/somefile"))
+	assert.Assert(t, !strings.HasPrefix(*in.Key, mockFileDataEncoded))
+	link, fragment, ok := strings.Cut(r.Stdout[0], "#")
+	assert.Assert(t, ok)
+	assert.Equal(t, link, "https://somebucket.s3.amazonaws.com/"+*in.Key)
+	key, err := fragmentKey(fragment)
+	assert.NilError(t, err)
+	got, err := decryptBytes(body, key)
+	assert.NilError(t, err)
+	assert.DeepEqual(t, got, mockFileData)
+}
+
+func TestRunEncryptErrors(t *testing.T) {
+	t.Run("directory", func(t *testing.T) {
+		r := newDirRun(t, fstest.MapFS{"d/a": {Data: []byte("a")}})
+		r.Uploader.Args = &[]string{"s3share", "--encrypt", "d"}
+
+		err := run(r.Uploader)
+
+		assert.ErrorIs(t, err, errEncryptDir)
+	})
+	t.Run("compressed", func(t *testing.T) {
+		r := newTestRun(t)
+		r.Uploader.Args = &[]string{"s3share", "--encrypt", "--gzip", "f"}
+
+		err := run(r.Uploader)
+
+		assert.ErrorIs(t, err, errEncryptCompress)
+	})
+}
+
+func TestWriteFile(t *testing.T) {
+	u := &Uploader{}
+	dir := t.TempDir()
+	name := filepath.Join(dir, "out")
+
+	assert.NilError(t, u.writeFile(name, strings.NewReader("data")))
+	b, err := os.ReadFile(name)
+	assert.NilError(t, err)
+	assert.Equal(t, string(b), "data")
+
+	err = u.writeFile(name, strings.NewReader("other"))
+	assert.ErrorIs(t, err, fs.ErrExist)
+
+	failed := filepath.Join(dir, "failed")
+	err = u.writeFile(failed, io.MultiReader(
+		strings.NewReader("part"), iotest.ErrReader(io.ErrUnexpectedEOF),
+	))
+	assert.ErrorIs(t, err, io.ErrUnexpectedEOF)
+	entries, err := os.ReadDir(dir)
+	assert.NilError(t, err)
+	assert.Equal(t, len(entries), 1)
+}
@@ -0,0 +1,219 @@
+package main
+
+import (
+	"fmt"
+	"maps"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	s3types "github.com/aws/aws-sdk-go-v2/service/s3/types"
+)
+
+// expiresMeta is the user metadata key holding the time after which gc may
+// delete an object, formatted as RFC 3339.
+const expiresMeta = "s3share-expires"
+
+// deleteBatch is the most keys DeleteObjects accepts at once.
+const deleteBatch = 1000
+
+// parseTTL parses a duration, additionally accepting a whole number of days
+// such as 7d.
+func parseTTL(s string) (time.Duration, error) {
+	var d time.Duration
+	if days, ok := strings.CutSuffix(s, "d"); ok {
+		n, err := strconv.Atoi(days)
+		if err != nil {
+			return 0, errBadTTL
+		}
+		d = time.Duration(n) * 24 * time.Hour
+	} else {
+		var err error
+		if d, err = time.ParseDuration(s); err != nil {
+			return 0, errBadTTL
+		}
+	}
+	if d <= 0 {
+		return 0, errBadTTL
+	}
+	return d, nil
+}
+
+// ttlValue is a flag.Value for durations parsed by parseTTL.
+type ttlValue struct {
+	d *time.Duration
+}
+
+func (v ttlValue) String() string {
+	if v.d == nil || *v.d == 0 {
+		return ""
+	}
+	return v.d.String()
+}
+
+func (v ttlValue) Set(s string) (err error) {
+	*v.d, err = parseTTL(s)
+	return err
+}
+
+func (u *Uploader) expiresAt() string {
+	return u.now().Add(u.TTL).UTC().Format(time.RFC3339)
+}
+
+// refreshExpiry makes sure an existing object at key lives at least as long
+// as one uploaded now would, so sharing a file again never gets the link
+// garbage collected early. Objects without an expiry are kept forever, and
+// sharing without a TTL removes the expiry.
+func (u *Uploader) refreshExpiry(key string) error {
+	if u.RefreshExpiry != nil {
+		return u.RefreshExpiry(key)
+	}
+
+	out, err := u.headObject(u.headInput(key))
+	if err != nil {
+		return err
+	}
+	cur, ok := out.Metadata[expiresMeta]
+	if !ok {
+		return nil
+	}
+	meta := maps.Clone(out.Metadata)
+	if u.TTL == 0 {
+		delete(meta, expiresMeta)
+	} else {
+		t, err := time.Parse(time.RFC3339, cur)
+		if err == nil && !t.Before(u.now().Add(u.TTL)) {
+			return nil
+		}
+		meta[expiresMeta] = u.expiresAt()
+	}
+
+	if u.DryRun {
+		u.logf("would update expiry of %s", key)
+		return nil
+	}
+
+	// Replacing metadata means copying the object onto itself, which resets
+	// every header that is not passed along again. Its encryption and
+	// storage class are kept rather than taken from the flags, which may
+	// differ from those it was uploaded with.
+	if size := aws.ToInt64(out.ContentLength); size > maxCopySize {
+		create := &s3.CreateMultipartUploadInput{
+			Bucket:               &u.Bucket,
+			Key:                  &key,
+			ACL:                  u.acl(),
+			Metadata:             meta,
+			CacheControl:         out.CacheControl,
+			ContentDisposition:   out.ContentDisposition,
+			ContentEncoding:      out.ContentEncoding,
+			ContentLanguage:      out.ContentLanguage,
+			ContentType:          out.ContentType,
+			ServerSideEncryption: out.ServerSideEncryption,
+			SSEKMSKeyId:          out.SSEKMSKeyId,
+			StorageClass:         out.StorageClass,
+		}
+		create.SSECustomerAlgorithm, create.SSECustomerKey,
+			create.SSECustomerKeyMD5 = u.ssec()
+		err = u.copyParts(key, create, size)
+	} else {
+		in := u.copyInput(key, key)
+		in.MetadataDirective = s3types.MetadataDirectiveReplace
+		in.Metadata = meta
+		in.CacheControl = out.CacheControl
+		in.ContentDisposition = out.ContentDisposition
+		in.ContentEncoding = out.ContentEncoding
+		in.ContentLanguage = out.ContentLanguage
+		in.ContentType = out.ContentType
+		in.ServerSideEncryption = out.ServerSideEncryption
+		in.SSEKMSKeyId = out.SSEKMSKeyId
+		in.StorageClass = out.StorageClass
+		_, err = u.copyObject(in)
+	}
+	if err != nil {
+		return fmt.Errorf("error updating expiry of %s: %w", key, err)
+	}
+	return nil
+}
+
+type gcResult struct {
+	Key     string    `json:"key"`
+	Expires time.Time `json:"expires"`
+}
+
+// gc deletes every object under the prefix whose expiry has passed. With
+// --dry-run it only reports them.
+func (u *Uploader) gc([]string) error {
+	now := u.now()
+	var expired []string
+	err := u.listObjects(u.keyPrefix(), func(obj s3types.Object) error {
+		out, err := u.headObject(u.headInput(*obj.Key))
+		if isNotFound(err) {
+			return nil
+		} else if err != nil {
+			return err
+		}
+
+		v, ok := out.Metadata[expiresMeta]
+		if !ok {
+			return nil
+		}
+		t, err := time.Parse(time.RFC3339, v)
+		if err != nil {
+			u.logf("skipping %s: bad expiry %q", *obj.Key, v)
+			return nil
+		} else if t.After(now) {
+			return nil
+		}
+
+		expired = append(expired, *obj.Key)
+		if u.JSON {
+			return u.printJSON(gcResult{Key: *obj.Key, Expires: t})
+		}
+		_, err = u.println(*obj.Key + "\t" + t.Format(time.RFC3339))
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	if u.DryRun {
+		u.logf("%d expired objects would be deleted", len(expired))
+		return nil
+	}
+	if err := u.deleteKeys(expired); err != nil {
+		return err
+	}
+	u.logf("deleted %d expired objects", len(expired))
+	return nil
+}
+
+// deleteKeys deletes keys in as few DeleteObjects calls as possible.
+func (u *Uploader) deleteKeys(keys []string) error {
+	for len(keys) > 0 {
+		batch := keys[:min(len(keys), deleteBatch)]
+		keys = keys[len(batch):]
+
+		ids := make([]s3types.ObjectIdentifier, len(batch))
+		for i := range batch {
+			ids[i] = s3types.ObjectIdentifier{Key: &batch[i]}
+		}
+		out, err := u.deleteObjects(&s3.DeleteObjectsInput{
+			Bucket: &u.Bucket,
+			Delete: &s3types.Delete{Objects: ids, Quiet: aws.Bool(true)},
+		})
+		if err != nil {
+			return err
+		}
+		if len(out.Errors) > 0 {
+			e := out.Errors[0]
+			return fmt.Errorf(
+				"error deleting %s: %s (and %d more)",
+				aws.ToString(e.Key), aws.ToString(e.Message),
+				len(out.Errors)-1,
+			)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,327 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	s3types "github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"gotest.tools/v3/assert"
+)
+
+var expiryNow = time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+
+func newExpiryRun(t *testing.T) *testRun {
+	r := newTestRun(t)
+	r.Uploader.Now = func() time.Time { return expiryNow }
+	r.Uploader.RefreshExpiry = nil
+	r.Uploader.Client = new(s3Client)
+	return r
+}
+
+func TestParseTTL(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		in   string
+		want time.Duration
+		err  error
+	}{
+		{"12h", 12 * time.Hour, nil},
+		{"7d", 7 * 24 * time.Hour, nil},
+		{"90m", 90 * time.Minute, nil},
+		{"0d", 0, errBadTTL},
+		{"-1h", 0, errBadTTL},
+		{"xd", 0, errBadTTL},
+		{"soon", 0, errBadTTL},
+	}
+	for _, tt := range tests {
+		got, err := parseTTL(tt.in)
+		if tt.err != nil {
+			assert.ErrorIs(t, err, tt.err, tt.in)
+			continue
+		}
+		assert.NilError(t, err, tt.in)
+		assert.Equal(t, got, tt.want, tt.in)
+	}
+}
+
+func TestRunPutTTL(t *testing.T) {
+	r := newTestRun(t)
+	r.Uploader.Args = &[]string{"s3share", "--ttl", "2d", "somefile"}
+	r.Uploader.UploadFile = nil
+	r.Uploader.Now = func() time.Time { return expiryNow }
+
+	err := run(r.Uploader)
+
+	assert.NilError(t, err)
+	assert.Equal(t, len(r.PutObjectCalls), 1)
+	assert.DeepEqual(t, r.PutObjectCalls[0].Metadata, map[string]string{
+		expiresMeta: "2024-01-04T03:04:05Z",
+	})
+}
+
+func TestRunPutBadTTL(t *testing.T) {
+	r := newTestRun(t)
+	r.Uploader.Args = &[]string{"s3share", "--ttl", "forever", "somefile"}
+
+	err := run(r.Uploader)
+
+	assert.ErrorContains(t, err, errBadTTL.Error())
+}
+
+func TestPutNoTTLNoMetadata(t *testing.T) {
+	r := newTestRun(t)
+	r.Uploader.UploadFile = nil
+
+	_, err := r.Uploader.uploadFile("somefile")
+
+	assert.NilError(t, err)
+	assert.Assert(t, r.PutObjectCalls[0].Metadata == nil)
+}
+
+func TestUploadExistingRefreshesExpiry(t *testing.T) {
+	r := newTestRun(t)
+	r.Uploader.UploadFile = nil
+	r.Uploader.ObjectExists = func(string) (bool, error) { return true, nil }
+
+	_, err := r.Uploader.uploadFile("somefile")
+
+	assert.NilError(t, err)
+	assert.Equal(t, len(r.PutObjectCalls), 0)
+	assert.DeepEqual(t, r.RefreshExpiryCalls, []string{
+		mockFileDataEncoded + "/somefile",
+	})
+}
+
+func TestRefreshExpiryExtends(t *testing.T) {
+	r := newExpiryRun(t)
+	r.Uploader.TTL = 48 * time.Hour
+	r.Uploader.Client._HeadObject_Return(&s3.HeadObjectOutput{
+		ContentType: aws.String("text/plain"),
+		Metadata: map[string]string{
+			expiresMeta: "2024-01-03T00:00:00Z",
+			"other":     "kept",
+		},
+		ServerSideEncryption: s3types.ServerSideEncryptionAwsKms,
+		SSEKMSKeyId:          aws.String("key-x"),
+		StorageClass:         s3types.StorageClassStandardIa,
+	}, nil)
+	r.Uploader.Client._CopyObject_Return(&s3.CopyObjectOutput{}, nil)
+
+	err := r.Uploader.refreshExpiry("abc/some file")
+
+	assert.NilError(t, err)
+	calls := r.Uploader.Client._CopyObject_Calls()
+	assert.Equal(t, len(calls), 1)
+	in := calls[0].Params
+	assert.Equal(t, *in.Key, "abc/some file")
+	assert.Equal(t, *in.CopySource, "somebucket/abc/some%20file")
+	assert.Equal(t, in.MetadataDirective, s3types.MetadataDirectiveReplace)
+	assert.Equal(t, *in.ContentType, "text/plain")
+	assert.DeepEqual(t, in.Metadata, map[string]string{
+		expiresMeta: "2024-01-04T03:04:05Z",
+		"other":     "kept",
+	})
+	assert.Equal(t, in.ServerSideEncryption,
+		s3types.ServerSideEncryptionAwsKms)
+	assert.Equal(t, *in.SSEKMSKeyId, "key-x")
+	assert.Equal(t, in.StorageClass, s3types.StorageClassStandardIa)
+}
+
+func TestRefreshExpiryLarge(t *testing.T) {
+	r := newExpiryRun(t)
+	r.Uploader.TTL = 48 * time.Hour
+	c := r.Uploader.Client
+	c._HeadObject_Return(&s3.HeadObjectOutput{
+		ContentLength: aws.Int64(maxCopySize + 1),
+		ContentType:   aws.String("application/zip"),
+		Metadata: map[string]string{
+			expiresMeta: "2024-01-03T00:00:00Z",
+		},
+		ServerSideEncryption: s3types.ServerSideEncryptionAwsKms,
+		SSEKMSKeyId:          aws.String("key-x"),
+		StorageClass:         s3types.StorageClassStandardIa,
+	}, nil)
+	c._CreateMultipartUpload_Return(&s3.CreateMultipartUploadOutput{
+		UploadId: aws.String("upload"),
+	}, nil)
+	c._UploadPartCopy_Return(&s3.UploadPartCopyOutput{
+		CopyPartResult: &s3types.CopyPartResult{},
+	}, nil)
+	c._CompleteMultipartUpload_Return(
+		&s3.CompleteMultipartUploadOutput{}, nil,
+	)
+
+	err := r.Uploader.refreshExpiry("abc/big.zip")
+
+	assert.NilError(t, err)
+	assert.Equal(t, len(c._CopyObject_Calls()), 0)
+	create := c._CreateMultipartUpload_Calls()[0].Params
+	assert.Equal(t, *create.Key, "abc/big.zip")
+	assert.Equal(t, *create.ContentType, "application/zip")
+	assert.DeepEqual(t, create.Metadata, map[string]string{
+		expiresMeta: "2024-01-04T03:04:05Z",
+	})
+	assert.Equal(t, create.ServerSideEncryption,
+		s3types.ServerSideEncryptionAwsKms)
+	assert.Equal(t, *create.SSEKMSKeyId, "key-x")
+	assert.Equal(t, create.StorageClass, s3types.StorageClassStandardIa)
+	copies := c._UploadPartCopy_Calls()
+	assert.Equal(t, len(copies), 11)
+	assert.Equal(t, *copies[0].Params.CopySource, "somebucket/abc/big.zip")
+	assert.Equal(t, len(c._CompleteMultipartUpload_Calls()), 1)
+}
+
+func TestRefreshExpiryKeepsLater(t *testing.T) {
+	r := newExpiryRun(t)
+	r.Uploader.TTL = time.Hour
+	r.Uploader.Client._HeadObject_Return(&s3.HeadObjectOutput{
+		Metadata: map[string]string{expiresMeta: "2024-02-01T00:00:00Z"},
+	}, nil)
+
+	err := r.Uploader.refreshExpiry("abc/somefile")
+
+	assert.NilError(t, err)
+	assert.Equal(t, len(r.Uploader.Client._CopyObject_Calls()), 0)
+}
+
+func TestRefreshExpiryPermanent(t *testing.T) {
+	r := newExpiryRun(t)
+	r.Uploader.TTL = time.Hour
+	r.Uploader.Client._HeadObject_Return(&s3.HeadObjectOutput{}, nil)
+
+	err := r.Uploader.refreshExpiry("abc/somefile")
+
+	assert.NilError(t, err)
+	assert.Equal(t, len(r.Uploader.Client._CopyObject_Calls()), 0)
+}
+
+func TestRefreshExpiryRemovedWithoutTTL(t *testing.T) {
+	r := newExpiryRun(t)
+	r.Uploader.Client._HeadObject_Return(&s3.HeadObjectOutput{
+		Metadata: map[string]string{expiresMeta: "2024-01-03T00:00:00Z"},
+	}, nil)
+	r.Uploader.Client._CopyObject_Return(&s3.CopyObjectOutput{}, nil)
+
+	err := r.Uploader.refreshExpiry("abc/somefile")
+
+	assert.NilError(t, err)
+	calls := r.Uploader.Client._CopyObject_Calls()
+	assert.Equal(t, len(calls), 1)
+	assert.DeepEqual(t, calls[0].Params.Metadata, map[string]string{})
+}
+
+func mockExpiryObjects(c *s3Client, expires map[string]string) {
+	var objs []s3types.Object
+	for _, key := range []string{"a/one", "b/two", "c/three", "d/four"} {
+		objs = append(objs, s3types.Object{Key: aws.String(key)})
+	}
+	c._ListObjectsV2_Return(&s3.ListObjectsV2Output{Contents: objs}, nil)
+	c._HeadObject_Do(func(
+		_ context.Context,
+		in *s3.HeadObjectInput,
+		_ ...func(*s3.Options),
+	) (*s3.HeadObjectOutput, error) {
+		out := &s3.HeadObjectOutput{}
+		if v, ok := expires[*in.Key]; ok {
+			out.Metadata = map[string]string{expiresMeta: v}
+		}
+		return out, nil
+	})
+}
+
+var gcExpires = map[string]string{
+	"a/one":   "2024-01-01T00:00:00Z",
+	"b/two":   "2024-02-01T00:00:00Z",
+	"d/four":  "2024-01-02T03:04:05Z",
+	"c/three": "garbage",
+}
+
+func TestGcDeletesExpired(t *testing.T) {
+	r := newExpiryRun(t)
+	mockExpiryObjects(r.Uploader.Client, gcExpires)
+	r.Uploader.Client._DeleteObjects_Return(&s3.DeleteObjectsOutput{}, nil)
+
+	err := r.Uploader.gc(nil)
+
+	assert.NilError(t, err)
+	assert.DeepEqual(t, r.Stdout, []string{
+		"a/one\t2024-01-01T00:00:00Z",
+		"d/four\t2024-01-02T03:04:05Z",
+	})
+	calls := r.Uploader.Client._DeleteObjects_Calls()
+	assert.Equal(t, len(calls), 1)
+	var keys []string
+	for _, id := range calls[0].Params.Delete.Objects {
+		keys = append(keys, *id.Key)
+	}
+	assert.DeepEqual(t, keys, []string{"a/one", "d/four"})
+	assert.DeepEqual(t, r.Stderr, []string{
+		`skipping c/three: bad expiry "garbage"`,
+		"deleted 2 expired objects",
+	})
+}
+
+func TestGcDryRun(t *testing.T) {
+	r := newExpiryRun(t)
+	r.Uploader.Args = &[]string{"s3share", "gc", "--dry-run"}
+	mockExpiryObjects(r.Uploader.Client, gcExpires)
+
+	err := run(r.Uploader)
+
+	assert.NilError(t, err)
+	assert.Equal(t, len(r.Stdout), 2)
+	assert.Equal(t, len(r.Uploader.Client._DeleteObjects_Calls()), 0)
+	assert.DeepEqual(t, r.Stderr[len(r.Stderr)-1],
+		"2 expired objects would be deleted")
+}
+
+func TestGcDeleteErrors(t *testing.T) {
+	r := newExpiryRun(t)
+	mockExpiryObjects(r.Uploader.Client, gcExpires)
+	r.Uploader.Client._DeleteObjects_Return(&s3.DeleteObjectsOutput{
+		Errors: []s3types.Error{
+			{Key: aws.String("a/one"), Message: aws.String("Access Denied")},
+		},
+	}, nil)
+
+	err := r.Uploader.gc(nil)
+
+	assert.Error(t, err, "error deleting a/one: Access Denied (and 0 more)")
+}
+
+func TestDeleteKeysBatches(t *testing.T) {
+	r := newExpiryRun(t)
+	r.Uploader.Client._DeleteObjects_Return(&s3.DeleteObjectsOutput{}, nil)
+	keys := make([]string, deleteBatch+1)
+	for i := range keys {
+		keys[i] = "k"
+	}
+
+	err := r.Uploader.deleteKeys(keys)
+
+	assert.NilError(t, err)
+	calls := r.Uploader.Client._DeleteObjects_Calls()
+	assert.Equal(t, len(calls), 2)
+	assert.Equal(t, len(calls[0].Params.Delete.Objects), deleteBatch)
+	assert.Equal(t, len(calls[1].Params.Delete.Objects), 1)
+}
+
+func TestRefreshExpiryDryRun(t *testing.T) {
+	r := newExpiryRun(t)
+	r.Uploader.DryRun = true
+	r.Uploader.TTL = time.Hour
+	r.Uploader.Client._HeadObject_Return(&s3.HeadObjectOutput{
+		Metadata: map[string]string{expiresMeta: "2024-01-01T00:00:00Z"},
+	}, nil)
+
+	err := r.Uploader.refreshExpiry("abc/somefile")
+
+	assert.NilError(t, err)
+	assert.Equal(t, len(r.Uploader.Client._CopyObject_Calls()), 0)
+	assert.DeepEqual(t, r.Stderr, []string{
+		"would update expiry of abc/somefile",
+	})
+}
@@ -0,0 +1,218 @@
+package main
+
+import (
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"net/url"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/klauspost/compress/zstd"
+)
+
+var errBadGetURL = errors.New("not an http or https URL")
+var errHashMismatch = errors.New(
+	"downloaded content does not match the hash in its key",
+)
+var errGetOutput = errors.New("-o needs exactly one link or key")
+
+// get downloads each shared link or key under the name it was shared as,
+// decrypting it if the link carries a key. Content whose key holds its
+// hash is checked against it as it streams.
+func (u *Uploader) get(args []string) error {
+	if len(args) < 1 {
+		return errHelp
+	} else if u.Output != "" && len(args) > 1 {
+		return errGetOutput
+	}
+
+	u.Progress = !u.Quiet && u.Output != "-" && u.isTerminal()
+	for _, arg := range args {
+		if err := u.download(arg); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// fetched is an object being downloaded.
+type fetched struct {
+	Key      string
+	Body     io.ReadCloser
+	Size     int64
+	Encoding string
+}
+
+func (u *Uploader) download(arg string) error {
+	ref, fragment, _ := strings.Cut(arg, "#")
+	secret, err := fragmentKey(fragment)
+	if err != nil {
+		return err
+	}
+
+	var obj *fetched
+	if strings.Contains(ref, "://") {
+		obj, err = u.fetchURL(ref)
+	} else {
+		obj, err = u.fetchKey(ref)
+	}
+	if err != nil {
+		return err
+	}
+	defer func() { _ = obj.Body.Close() }()
+
+	// Templates without a {name} leave it empty, so the file is saved under
+	// the last segment of its key instead.
+	hash, name, ok := matchKey(u.keyPattern(false, false), obj.Key)
+	if !ok || name == "" {
+		name = path.Base(obj.Key)
+	}
+	if name == "/" || name == "." {
+		return fmt.Errorf("%w: %s", errBadGetURL, ref)
+	}
+	p := u.newProgress("downloading "+name, obj.Size)
+	defer p.finish()
+	r := p.reader(obj.Body)
+
+	// Encrypted objects are keyed by the hash of their ciphertext and
+	// compressed ones by the hash of what they decompress to.
+	if hash == "" {
+		u.logf("cannot verify %s: its key holds no content hash", name)
+	}
+	if secret != nil {
+		if r, err = newDecryptReader(verify(r, hash), secret); err != nil {
+			return err
+		}
+	} else {
+		dr, err := decode(r, obj.Encoding)
+		if err != nil {
+			return fmt.Errorf("error decoding %s: %w", name, err)
+		}
+		defer func() { _ = dr.Close() }()
+		r = verify(dr, hash)
+	}
+
+	if u.Output == "-" {
+		_, err = io.Copy(u.stdout(), r)
+		return err
+	}
+	dest := name
+	if u.Output != "" {
+		dest = u.Output
+		if fi, err := u.stat(dest); err == nil && fi.IsDir() {
+			dest = filepath.Join(dest, name)
+		}
+	}
+	if err := u.writeFile(dest, r); err != nil {
+		return fmt.Errorf("error saving %s: %w", dest, err)
+	}
+	u.logf("saved %s", dest)
+	return nil
+}
+
+// fetchURL downloads a shared link over HTTP, which needs no credentials.
+func (u *Uploader) fetchURL(link string) (*fetched, error) {
+	ref, err := url.Parse(link)
+	if err != nil || (ref.Scheme != "http" && ref.Scheme != "https") {
+		return nil, fmt.Errorf("%w: %s", errBadGetURL, link)
+	}
+	key := strings.TrimPrefix(ref.Path, "/")
+	if u.Bucket != "" {
+		if k, err := u.urlKey(link); err == nil {
+			key = k
+		}
+	}
+
+	resp, err := u.fetch(link)
+	if err != nil {
+		return nil, err
+	}
+	return &fetched{
+		Key:      key,
+		Body:     resp.Body,
+		Size:     max(resp.ContentLength, 0),
+		Encoding: resp.Header.Get("Content-Encoding"),
+	}, nil
+}
+
+// fetchKey downloads the object at key from the bucket.
+func (u *Uploader) fetchKey(key string) (*fetched, error) {
+	if u.Bucket == "" {
+		return nil, errNoBucket
+	}
+	in := &s3.GetObjectInput{
+		Bucket: &u.Bucket,
+		Key:    &key,
+	}
+	in.SSECustomerAlgorithm, in.SSECustomerKey, in.SSECustomerKeyMD5 = u.ssec()
+	out, err := u.getObject(in)
+	if err != nil {
+		return nil, fmt.Errorf("error fetching %s: %w", key, err)
+	}
+	var size int64
+	if out.ContentLength != nil {
+		size = *out.ContentLength
+	}
+	var encoding string
+	if out.ContentEncoding != nil {
+		encoding = *out.ContentEncoding
+	}
+	return &fetched{
+		Key:      key,
+		Body:     out.Body,
+		Size:     size,
+		Encoding: encoding,
+	}, nil
+}
+
+// decode undoes the Content-Encoding an object was uploaded with.
+func decode(r io.Reader, encoding string) (io.ReadCloser, error) {
+	switch encoding {
+	case "", "identity":
+		return io.NopCloser(r), nil
+	case compressGzip:
+		return gzip.NewReader(r)
+	case compressZstd:
+		zr, err := zstd.NewReader(r, zstd.WithDecoderConcurrency(1))
+		if err != nil {
+			return nil, err
+		}
+		return zr.IOReadCloser(), nil
+	default:
+		return nil, fmt.Errorf("unknown content encoding: %s", encoding)
+	}
+}
+
+// verifyReader fails at the end of its input if the base64url encoding of
+// the input's hash does not start with the hash expected, which key
+// templates may have shortened.
+type verifyReader struct {
+	r    io.Reader
+	hash hash.Hash
+	want string
+}
+
+func verify(r io.Reader, want string) io.Reader {
+	if want == "" {
+		return r
+	}
+	return &verifyReader{r, sha256.New(), want}
+}
+
+func (v *verifyReader) Read(p []byte) (int, error) {
+	n, err := v.r.Read(p)
+	v.hash.Write(p[:n])
+	if err == io.EOF && !strings.HasPrefix(
+		base64.RawURLEncoding.EncodeToString(v.hash.Sum(nil)), v.want,
+	) {
+		return n, errHashMismatch
+	}
+	return n, err
+}
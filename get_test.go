@@ -0,0 +1,233 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"io"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/klauspost/compress/zstd"
+	"gotest.tools/v3/assert"
+)
+
+const testGetHost = "https://files.example.com/"
+
+// hashKey returns the key b is shared under as name.
+func hashKey(b []byte, name string) string {
+	sum := sha256.Sum256(b)
+	return base64.RawURLEncoding.EncodeToString(sum[:]) + "/" + name
+}
+
+type getRun struct {
+	*testRun
+	Files map[string][]byte
+}
+
+// newGetRun serves object at key over HTTP, with no bucket configured.
+func newGetRun(t *testing.T, key string, object []byte) *getRun {
+	r := &getRun{testRun: newTestRun(t), Files: map[string][]byte{}}
+	r.Uploader.Getenv = func(string) string { return "" }
+	r.Uploader.SetupClient = func() error {
+		t.Error("getting a link needs no client")
+		return nil
+	}
+	r.Uploader.Fetch = func(link string) (*http.Response, error) {
+		if link != testGetHost+key {
+			return nil, errors.New("not found")
+		}
+		return &http.Response{
+			Body:          io.NopCloser(bytes.NewReader(object)),
+			ContentLength: int64(len(object)),
+			Header:        http.Header{},
+		}, nil
+	}
+	r.Uploader.WriteFile = func(name string, rd io.Reader) error {
+		b, err := io.ReadAll(rd)
+		if err != nil {
+			return err
+		}
+		r.Files[name] = b
+		return nil
+	}
+	return r
+}
+
+func TestRunGet(t *testing.T) {
+	key := hashKey(mockFileData, "report.pdf")
+	r := newGetRun(t, key, mockFileData)
+	r.Uploader.Args = &[]string{"s3share", "get", testGetHost + key}
+
+	err := run(r.Uploader)
+
+	assert.NilError(t, err)
+	assert.DeepEqual(t, r.Files, map[string][]byte{"report.pdf": mockFileData})
+	assert.DeepEqual(t, r.Stderr, []string{"saved report.pdf"})
+}
+
+func TestRunGetEncrypted(t *testing.T) {
+	sealed := encryptBytes(t, mockFileData)
+	key := hashKey(sealed, "report.pdf")
+	r := newGetRun(t, key, sealed)
+	r.Uploader.Args = &[]string{
+		"s3share", "get", testGetHost + key + "#k=" +
+			base64.RawURLEncoding.EncodeToString(testEncryptKey),
+	}
+
+	err := run(r.Uploader)
+
+	assert.NilError(t, err)
+	assert.DeepEqual(t, r.Files["report.pdf"], mockFileData)
+}
+
+func TestRunGetMismatch(t *testing.T) {
+	key := hashKey([]byte("original"), "report.pdf")
+	r := newGetRun(t, key, mockFileData)
+	r.Uploader.Args = &[]string{"s3share", "get", testGetHost + key}
+
+	err := run(r.Uploader)
+
+	assert.ErrorIs(t, err, errHashMismatch)
+	assert.Equal(t, len(r.Files), 0)
+}
+
+func TestRunGetUnverified(t *testing.T) {
+	key := hashKey([]byte("dir manifest"), "report/a.txt")
+	r := newGetRun(t, key, mockFileData)
+	r.Uploader.Args = &[]string{"s3share", "get", testGetHost + key}
+
+	err := run(r.Uploader)
+
+	assert.NilError(t, err)
+	assert.DeepEqual(t, r.Files["a.txt"], mockFileData)
+	assert.DeepEqual(t, r.Stderr, []string{
+		"cannot verify a.txt: its key holds no content hash",
+		"saved a.txt",
+	})
+}
+
+func TestRunGetOutput(t *testing.T) {
+	key := hashKey(mockFileData, "report.pdf")
+	t.Run("file", func(t *testing.T) {
+		r := newGetRun(t, key, mockFileData)
+		r.Uploader.Args = &[]string{
+			"s3share", "get", "-o", "out.pdf", testGetHost + key,
+		}
+
+		assert.NilError(t, run(r.Uploader))
+		assert.DeepEqual(t, r.Files["out.pdf"], mockFileData)
+	})
+	t.Run("directory", func(t *testing.T) {
+		r := newGetRun(t, key, mockFileData)
+		dir := t.TempDir()
+		r.Uploader.Stat = nil
+		r.Uploader.Args = &[]string{
+			"s3share", "get", testGetHost + key, "-o", dir,
+		}
+
+		assert.NilError(t, run(r.Uploader))
+		assert.DeepEqual(t, r.Files[filepath.Join(dir, "report.pdf")],
+			mockFileData)
+	})
+	t.Run("stdout", func(t *testing.T) {
+		r := newGetRun(t, key, mockFileData)
+		var out bytes.Buffer
+		r.Uploader.Stdout = &out
+		r.Uploader.Args = &[]string{
+			"s3share", "get", "-o", "-", testGetHost + key,
+		}
+
+		assert.NilError(t, run(r.Uploader))
+		assert.DeepEqual(t, out.Bytes(), mockFileData)
+		assert.Equal(t, len(r.Files), 0)
+	})
+	t.Run("several links", func(t *testing.T) {
+		r := newGetRun(t, key, mockFileData)
+		r.Uploader.Args = &[]string{
+			"s3share", "get", "-o", "out", testGetHost + key, "other",
+		}
+
+		assert.ErrorIs(t, run(r.Uploader), errGetOutput)
+	})
+}
+
+func TestRunGetKey(t *testing.T) {
+	var gz bytes.Buffer
+	zw := gzip.NewWriter(&gz)
+	_, _ = zw.Write(mockFileData)
+	assert.NilError(t, zw.Close())
+	key := "shares/" + hashKey(mockFileData, "report.pdf")
+	r := newGetRun(t, key, nil)
+	r.Uploader.Getenv = testUploader.Getenv
+	r.Uploader.SetupClient = nil
+	c := new(s3Client)
+	r.Uploader.Client = c
+	c._GetObject_Do(func(
+		_ context.Context, in *s3.GetObjectInput, _ ...func(*s3.Options),
+	) (*s3.GetObjectOutput, error) {
+		assert.Equal(t, *in.Key, key)
+		return &s3.GetObjectOutput{
+			Body:            io.NopCloser(bytes.NewReader(gz.Bytes())),
+			ContentEncoding: aws.String("gzip"),
+		}, nil
+	})
+	r.Uploader.Args = &[]string{
+		"s3share", "get", "--bucket", "somebucket", key,
+	}
+
+	err := run(r.Uploader)
+
+	assert.NilError(t, err)
+	assert.DeepEqual(t, r.Files["report.pdf"], mockFileData)
+}
+
+func TestRunGetErrors(t *testing.T) {
+	sealed := encryptBytes(t, mockFileData)
+	key := hashKey(sealed, "report.pdf")
+	tests := []struct {
+		name string
+		arg  string
+		err  error
+	}{
+		{"bad key", testGetHost + key + "#k=short", errBadFragmentKey},
+		{"wrong key", testGetHost + key + "#k=" + strings.Repeat("A", 43),
+			errDecrypt},
+		{"not http", "ftp://files.example.com/" + key, errBadGetURL},
+		{"key without bucket", key, errNoBucket},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := newGetRun(t, key, sealed)
+			r.Uploader.Args = &[]string{"s3share", "get", tt.arg}
+
+			err := run(r.Uploader)
+
+			assert.ErrorIs(t, err, tt.err)
+			assert.Equal(t, len(r.Files), 0)
+		})
+	}
+}
+
+func TestDecode(t *testing.T) {
+	zw, err := zstd.NewWriter(nil)
+	assert.NilError(t, err)
+	packed := zw.EncodeAll(mockFileData, nil)
+
+	dr, err := decode(bytes.NewReader(packed), "zstd")
+	assert.NilError(t, err)
+	b, err := io.ReadAll(dr)
+	assert.NilError(t, err)
+	assert.NilError(t, dr.Close())
+	assert.DeepEqual(t, b, mockFileData)
+
+	_, err = decode(bytes.NewReader(packed), "br")
+	assert.ErrorContains(t, err, "unknown content encoding")
+}
@@ -0,0 +1,224 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var errBadKeyTemplate = errors.New("bad key template")
+
+// defaultKeyTemplate lays keys out as they have always been.
+const defaultKeyTemplate = "{prefix}/{hash}/{name}"
+
+// minHashLen is the shortest {hash:N} allowed. Shorter hashes would make
+// two different files likely to share a key.
+const minHashLen = 8
+
+var templateVar = regexp.MustCompile(`\{(\w+)(?::(\d+))?\}`)
+
+// templateVarPatterns match what each template variable expands to.
+var templateVarPatterns = map[string]string{
+	"prefix": `.*?`,
+	"hash":   `[A-Za-z0-9_-]+`,
+	"name":   `[^/]+`,
+	"ext":    `[^/]*`,
+	"user":   `[^/]+`,
+	"host":   `[^/]+`,
+	"date":   `\d{4}-\d{2}-\d{2}`,
+	"random": `[A-Za-z0-9_-]+`,
+}
+
+func (u *Uploader) keyTemplate() string {
+	if u.KeyTemplate == "" {
+		return defaultKeyTemplate
+	}
+	return u.KeyTemplate
+}
+
+func checkKeyTemplate(tmpl string) error {
+	for _, m := range templateVar.FindAllStringSubmatch(tmpl, -1) {
+		if _, ok := templateVarPatterns[m[1]]; !ok {
+			return fmt.Errorf(
+				"%w: unknown variable %s", errBadKeyTemplate, m[0],
+			)
+		}
+		if m[2] == "" {
+			continue
+		} else if m[1] != "hash" {
+			return fmt.Errorf(
+				"%w: only {hash} takes a length", errBadKeyTemplate,
+			)
+		}
+		n, err := strconv.Atoi(m[2])
+		if err != nil || n < minHashLen || n > 43 {
+			return fmt.Errorf("%w: {hash:N} needs N from %d to 43",
+				errBadKeyTemplate, minHashLen)
+		}
+	}
+	if strings.ContainsAny(templateVar.ReplaceAllString(tmpl, ""), "{}") {
+		return fmt.Errorf("%w: unmatched brace", errBadKeyTemplate)
+	}
+	if !strings.Contains(tmpl, "{hash") && !strings.Contains(tmpl, "{random}") {
+		return fmt.Errorf("%w: needs {hash} or {random}", errBadKeyTemplate)
+	}
+	return nil
+}
+
+// objectKey returns the key to share content with the given hash under as
+// name, laid out by the key template. Empty path segments, such as that
+// of an unset prefix, are left out.
+func (u *Uploader) objectKey(sum []byte, name string) string {
+	hash := base64.RawURLEncoding.EncodeToString(sum)
+	expand := func(v string) string {
+		m := templateVar.FindStringSubmatch(v)
+		switch m[1] {
+		case "prefix":
+			return strings.Trim(u.Prefix, "/")
+		case "hash":
+			if n, err := strconv.Atoi(m[2]); err == nil && n < len(hash) {
+				return hash[:n]
+			}
+			return hash
+		case "name":
+			return name
+		case "ext":
+			return strings.TrimPrefix(path.Ext(name), ".")
+		case "user":
+			return u.keyUser()
+		case "host":
+			return u.keyHost()
+		case "date":
+			return u.now().UTC().Format(time.DateOnly)
+		case "random":
+			b := make([]byte, 8)
+			_, _ = rand.Read(b)
+			return base64.RawURLEncoding.EncodeToString(b)
+		}
+		return v
+	}
+
+	key := templateVar.ReplaceAllStringFunc(u.keyTemplate(), expand)
+	var segs []string
+	for _, s := range strings.Split(key, "/") {
+		if s != "" {
+			segs = append(segs, s)
+		}
+	}
+	return strings.Join(segs, "/")
+}
+
+// dedupes reports whether sharing the same content twice gives the same
+// key, so that an existing object there can be reused.
+func (u *Uploader) dedupes() bool {
+	tmpl := u.keyTemplate()
+	return strings.Contains(tmpl, "{hash") &&
+		!strings.Contains(tmpl, "{random}")
+}
+
+// keyPattern matches the keys objectKey makes, with a hash and a name
+// group. Anchored patterns only match keys under the prefix; others match
+// the end of anything, such as the path of a URL. Keys of files in shared
+// directories continue past the name and match only if nested is set, with
+// the rest in a rest group.
+func (u *Uploader) keyPattern(anchored, nested bool) *regexp.Regexp {
+	var b strings.Builder
+	if anchored {
+		b.WriteString("^")
+	} else {
+		b.WriteString("(?:^|/)")
+	}
+
+	captured := map[string]bool{}
+	for _, seg := range strings.Split(u.keyTemplate(), "/") {
+		var sb strings.Builder
+		canEmpty := true
+		last := 0
+		for _, m := range templateVar.FindAllStringSubmatchIndex(seg, -1) {
+			lit := seg[last:m[0]]
+			sb.WriteString(regexp.QuoteMeta(lit))
+			last = m[1]
+			canEmpty = canEmpty && lit == ""
+
+			name := seg[m[2]:m[3]]
+			pat := templateVarPatterns[name]
+			switch {
+			case name == "prefix" && anchored:
+				pat = regexp.QuoteMeta(strings.Trim(u.Prefix, "/"))
+			case name == "hash" && m[4] >= 0:
+				pat = `[A-Za-z0-9_-]{` + seg[m[4]:m[5]] + `}`
+			case name == "hash":
+				pat = `[A-Za-z0-9_-]{43}`
+			}
+			canEmpty = canEmpty && (name == "ext" || name == "prefix" &&
+				(!anchored || strings.Trim(u.Prefix, "/") == ""))
+			if (name == "hash" || name == "name") && !captured[name] {
+				captured[name] = true
+				pat = "(?P<" + name + ">" + pat + ")"
+			}
+			sb.WriteString(pat)
+		}
+		sb.WriteString(regexp.QuoteMeta(seg[last:]))
+		canEmpty = canEmpty && seg[last:] == ""
+
+		if canEmpty {
+			fmt.Fprintf(&b, "(?:%s/)?", sb.String())
+		} else {
+			fmt.Fprintf(&b, "%s/", sb.String())
+		}
+	}
+	if nested {
+		b.WriteString("(?P<rest>.+/)?")
+	}
+	b.WriteString("$")
+	return regexp.MustCompile(b.String())
+}
+
+// matchKey splits a key made by objectKey into its base64url encoded hash,
+// which may be shortened or missing depending on the template, and the
+// name it was shared under.
+func matchKey(re *regexp.Regexp, key string) (hash, name string, ok bool) {
+	m := re.FindStringSubmatch(key + "/")
+	if m == nil {
+		return "", "", false
+	}
+	if i := re.SubexpIndex("hash"); i >= 0 {
+		hash = m[i]
+	}
+	if i := re.SubexpIndex("name"); i >= 0 {
+		name = m[i]
+	}
+	if i := re.SubexpIndex("rest"); i >= 0 && m[i] != "" {
+		name = path.Join(name, m[i])
+	}
+	return hash, name, true
+}
+
+// keyUser returns the name of the current user for {user}, without any
+// domain, or unknown.
+func (u *Uploader) keyUser() string {
+	name, err := u.username()
+	if err != nil || name == "" {
+		return "unknown"
+	}
+	// Windows account names carry their domain.
+	if i := strings.LastIndexAny(name, `\/`); i >= 0 {
+		name = name[i+1:]
+	}
+	return name
+}
+
+// keyHost returns the host name for {host}, or unknown.
+func (u *Uploader) keyHost() string {
+	name, err := u.hostname()
+	if err != nil || name == "" {
+		return "unknown"
+	}
+	return strings.ReplaceAll(name, "/", "-")
+}
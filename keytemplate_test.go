@@ -0,0 +1,256 @@
+package main
+
+import (
+	"crypto/sha256"
+	"errors"
+	"io/fs"
+	"path"
+	"strings"
+	"testing"
+	"time"
+
+	"gotest.tools/v3/assert"
+)
+
+func newTemplateRun(t *testing.T, tmpl string) *testRun {
+	r := newTestRun(t)
+	r.Uploader.KeyTemplate = tmpl
+	r.Uploader.Prefix = "shares/"
+	r.Uploader.Now = func() time.Time { return testNow }
+	r.Uploader.Username = func() (string, error) { return `CORP\ada`, nil }
+	r.Uploader.Hostname = func() (string, error) { return "build-01", nil }
+	return r
+}
+
+func TestObjectKeyTemplate(t *testing.T) {
+	tests := []struct {
+		tmpl, prefix, want string
+	}{
+		{"", "", mockFileDataEncoded + "/report.pdf"},
+		{"", "/shares/", "shares/" + mockFileDataEncoded + "/report.pdf"},
+		{"{prefix}/{date}/{hash:12}/{name}", "",
+			"2024-01-02/M_PXf7Ma7qaZ/report.pdf"},
+		{"{user}@{host}/{hash:8}.{ext}", "shares", "ada@build-01/M_PXf7Ma.pdf"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.tmpl, func(t *testing.T) {
+			r := newTemplateRun(t, tt.tmpl)
+			r.Uploader.Prefix = tt.prefix
+
+			sum := sha256.Sum256(mockFileData)
+			key := r.Uploader.objectKey(sum[:], "report.pdf")
+
+			assert.Equal(t, key, tt.want)
+		})
+	}
+}
+
+func TestObjectKeyRandom(t *testing.T) {
+	r := newTemplateRun(t, "{prefix}/{random}/{name}")
+
+	a := r.Uploader.objectKey(nil, "report.pdf")
+	b := r.Uploader.objectKey(nil, "report.pdf")
+
+	assert.Assert(t, a != b)
+	assert.Assert(t, strings.HasPrefix(a, "shares/"))
+	assert.Assert(t, strings.HasSuffix(a, "/report.pdf"))
+	assert.Assert(t, !r.Uploader.dedupes())
+}
+
+func TestCheckKeyTemplate(t *testing.T) {
+	t.Parallel()
+	for _, tmpl := range []string{
+		defaultKeyTemplate,
+		"{prefix}/{date}/{hash:12}/{name}",
+		"{user}/{host}/{random}.{ext}",
+	} {
+		assert.NilError(t, checkKeyTemplate(tmpl), tmpl)
+	}
+	for _, tmpl := range []string{
+		"{prefix}/{hash}/{nmae}",
+		"{hash:4}/{name}",
+		"{hash:44}/{name}",
+		"{name:3}/{hash}",
+		"{hash}/{name",
+		"{date}/{name}",
+	} {
+		assert.ErrorIs(t, checkKeyTemplate(tmpl), errBadKeyTemplate, tmpl)
+	}
+}
+
+func TestRunKeyTemplateNeedsPrefix(t *testing.T) {
+	tests := []struct {
+		name string
+		args []string
+		env  string
+	}{
+		{"flag", []string{"--prefix", "shares"}, ""},
+		{"env", nil, "shares/"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := newTestRun(t)
+			r.Uploader.Getenv = func(name string) string {
+				if name == "S3SHARE_PREFIX" {
+					return tt.env
+				}
+				return ""
+			}
+			r.Uploader.Args = &[]string{"s3share", "ls"}
+			*r.Uploader.Args = append(*r.Uploader.Args, tt.args...)
+			*r.Uploader.Args = append(*r.Uploader.Args,
+				"--bucket", "b", "--key-template", "{date}/{hash}/{name}")
+
+			err := run(r.Uploader)
+
+			assert.ErrorIs(t, err, errBadKeyTemplate)
+			assert.ErrorContains(t, err, "{prefix}")
+		})
+	}
+}
+
+func TestMatchKey(t *testing.T) {
+	r := newTemplateRun(t, "{prefix}/{date}/{hash:12}/{name}")
+	anchored := r.Uploader.keyPattern(true, true)
+	unanchored := r.Uploader.keyPattern(false, false)
+
+	hash, name, ok := matchKey(anchored,
+		"shares/2024-01-02/M_PXf7Ma7qaZ/report.pdf")
+	assert.Assert(t, ok)
+	assert.Equal(t, hash, "M_PXf7Ma7qaZ")
+	assert.Equal(t, name, "report.pdf")
+
+	hash, name, ok = matchKey(anchored,
+		"shares/2024-01-02/M_PXf7Ma7qaZ/dir/a/b.txt")
+	assert.Assert(t, ok)
+	assert.Equal(t, hash, "M_PXf7Ma7qaZ")
+	assert.Equal(t, name, "dir/a/b.txt")
+
+	hash, name, ok = matchKey(unanchored,
+		"bucket/shares/2024-01-02/M_PXf7Ma7qaZ/report.pdf")
+	assert.Assert(t, ok)
+	assert.Equal(t, hash, "M_PXf7Ma7qaZ")
+	assert.Equal(t, name, "report.pdf")
+
+	for _, key := range []string{
+		"2024-01-02/M_PXf7Ma7qaZ/report.pdf",
+		"shares/yesterday/M_PXf7Ma7qaZ/report.pdf",
+		"shares/2024-01-02/short/report.pdf",
+	} {
+		_, _, ok := matchKey(anchored, key)
+		assert.Assert(t, !ok, key)
+	}
+	_, _, ok = matchKey(unanchored,
+		"shares/2024-01-02/M_PXf7Ma7qaZ/dir/a/b.txt")
+	assert.Assert(t, !ok)
+}
+
+func TestKeyUserHost(t *testing.T) {
+	r := newTemplateRun(t, "")
+	assert.Equal(t, r.Uploader.keyUser(), "ada")
+	assert.Equal(t, r.Uploader.keyHost(), "build-01")
+
+	r.Uploader.Username = func() (string, error) {
+		return "", errors.New("no user")
+	}
+	r.Uploader.Hostname = func() (string, error) { return "", nil }
+	assert.Equal(t, r.Uploader.keyUser(), "unknown")
+	assert.Equal(t, r.Uploader.keyHost(), "unknown")
+}
+
+func TestRunKeyTemplate(t *testing.T) {
+	r := newTemplateRun(t, "")
+	r.Uploader.UploadFile = nil
+	r.Uploader.Args = &[]string{
+		"s3share", "--key-template", "{prefix}/{hash:12}/{name}", "f.txt",
+	}
+
+	err := run(r.Uploader)
+
+	assert.NilError(t, err)
+	assert.DeepEqual(t, r.ObjectExistsCalls, []string{"M_PXf7Ma7qaZ/f.txt"})
+	assert.Equal(t, *r.PutObjectCalls[0].Key, "M_PXf7Ma7qaZ/f.txt")
+}
+
+func TestRunKeyTemplateRandom(t *testing.T) {
+	r := newTemplateRun(t, "")
+	r.Uploader.UploadFile = nil
+	r.Uploader.Args = &[]string{
+		"s3share", "--key-template", "{random}/{name}", "f.txt",
+	}
+
+	err := run(r.Uploader)
+
+	assert.NilError(t, err)
+	assert.Equal(t, len(r.ObjectExistsCalls), 0)
+	assert.Equal(t, len(r.PutObjectCalls), 1)
+}
+
+func TestRmHashTemplate(t *testing.T) {
+	r := newTemplateRun(t, "{prefix}/{date}/{hash:12}/{name}")
+	r.Uploader.Stat = func(string) (fs.FileInfo, error) {
+		return nil, fs.ErrNotExist
+	}
+	r.Uploader.Client = new(s3Client)
+	mockRmObjects(r.Uploader.Client,
+		"shares/2024-01-02/M_PXf7Ma7qaZ/a.txt",
+		"shares/2024-01-02/47DEQpj8HBSa/b.txt",
+	)
+
+	err := r.Uploader.rm([]string{lsHashA})
+
+	assert.NilError(t, err)
+	assert.DeepEqual(t, rmDeleted(r.Uploader.Client), []string{
+		"shares/2024-01-02/M_PXf7Ma7qaZ/a.txt",
+	})
+}
+
+func TestRunGetTemplate(t *testing.T) {
+	key := "bucket/2024-01-02/" + hashKey(mockFileData, "report.pdf")[:12] +
+		"/report.pdf"
+	r := newGetRun(t, key, mockFileData)
+	r.Uploader.Getenv = func(name string) string {
+		if name == "S3SHARE_KEY_TEMPLATE" {
+			return "{prefix}/{date}/{hash:12}/{name}"
+		}
+		return ""
+	}
+	r.Uploader.Args = &[]string{"s3share", "get", testGetHost + key}
+
+	err := run(r.Uploader)
+
+	assert.NilError(t, err)
+	assert.DeepEqual(t, r.Files["report.pdf"], mockFileData)
+	assert.DeepEqual(t, r.Stderr, []string{"saved report.pdf"})
+}
+
+func TestRunGetTemplateWithoutName(t *testing.T) {
+	hash := strings.TrimSuffix(hashKey(mockFileData, ""), "/")
+	tests := []struct {
+		tmpl, key string
+	}{
+		{"{hash}", hash},
+		{"{prefix}/{hash}.{ext}", "shares/" + hash + ".pdf"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.tmpl, func(t *testing.T) {
+			r := newGetRun(t, tt.key, mockFileData)
+			r.Uploader.Getenv = func(name string) string {
+				if name == "S3SHARE_KEY_TEMPLATE" {
+					return tt.tmpl
+				}
+				return ""
+			}
+			r.Uploader.Args = &[]string{
+				"s3share", "get", testGetHost + tt.key,
+			}
+
+			err := run(r.Uploader)
+
+			assert.NilError(t, err)
+			name := path.Base(tt.key)
+			assert.DeepEqual(t, r.Files[name], mockFileData)
+			assert.DeepEqual(t, r.Stderr, []string{"saved " + name})
+		})
+	}
+}
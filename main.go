@@ -11,21 +11,21 @@ func run(u *Uploader) error {
 		return errHelp
 	}
 
-	u.Bucket = u.getenv("S3SHARE_BUCKET")
+	cmd, args, err := u.parseCommand(u.args()[1:])
+	if err != nil {
+		return err
+	}
+
+	if cmd.NoBucket {
+		return cmd.Run(u, args)
+	}
 	if u.Bucket == "" {
-		return errEnvNotSet
+		return errNoBucket
 	}
 
 	if err := u.setupClient(); err != nil {
 		return err
 	}
 
-	for _, f := range u.args()[1:] {
-		url, err := u.uploadFile(f)
-		if err != nil {
-			return err
-		}
-		_, _ = u.println(url)
-	}
-	return nil
+	return cmd.Run(u, args)
 }
@@ -0,0 +1,187 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	s3manager "github.com/aws/aws-sdk-go-v2/feature/s3/manager"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+var errBadPartSize = errors.New(
+	"part size must be between 5MiB and 5GiB, such as 64MiB",
+)
+var errBadMaxParts = errors.New("max parts must be between 1 and 10000.")
+var errBadConcurrency = errors.New("concurrency must be a positive number.")
+var errTooManyParts = errors.New(
+	"file does not fit in --max-parts parts of at most 5GiB",
+)
+
+const (
+	minPartSize = 5 << 20
+	maxPartSize = 5 << 30
+)
+
+// parseSize parses a byte count with an optional binary unit suffix, such
+// as 64MiB, 64M or 1G.
+func parseSize(s string) (int64, error) {
+	num := strings.TrimRight(s, "KMGTkmgtiIbB")
+	unit := strings.ToUpper(s[len(num):])
+	unit = strings.TrimSuffix(strings.TrimSuffix(unit, "B"), "I")
+	var shift uint
+	if unit != "" {
+		i := strings.Index("KMGT", unit)
+		if len(unit) != 1 || i < 0 {
+			return 0, fmt.Errorf("bad size: %s", s)
+		}
+		shift = 10 * uint(i+1)
+	}
+	n, err := strconv.ParseInt(num, 10, 64)
+	if err != nil || n < 0 || n > (1<<62)>>shift {
+		return 0, fmt.Errorf("bad size: %s", s)
+	}
+	return n << shift, nil
+}
+
+// sizeValue is a flag.Value for byte counts parsed by parseSize.
+type sizeValue struct {
+	n *int64
+}
+
+func (v sizeValue) String() string {
+	if v.n == nil || *v.n == 0 {
+		return ""
+	}
+	return formatSize(*v.n)
+}
+
+func (v sizeValue) Set(s string) (err error) {
+	*v.n, err = parseSize(s)
+	return err
+}
+
+// uploadOptions configures the uploader putObject uses for an object of
+// size bytes, or 0 if the size is unknown.
+func (u *Uploader) uploadOptions(size int64) func(*s3manager.Uploader) {
+	return func(m *s3manager.Uploader) {
+		if u.PartSize != 0 {
+			m.PartSize = u.PartSize
+		}
+		if u.Concurrency != 0 {
+			m.Concurrency = u.Concurrency
+		}
+		if u.MaxParts != 0 {
+			m.MaxUploadParts = int32(u.MaxParts)
+		}
+		m.LeavePartsOnError = u.LeaveParts
+
+		// Grow parts of large files to fit within the part limit, rounded
+		// up to a whole MiB, but no larger than S3 accepts. Files that
+		// still do not fit are refused by putObject.
+		if parts := int64(m.MaxUploadParts); size > m.PartSize*parts {
+			const mib = 1 << 20
+			m.PartSize = min(
+				((size+parts-1)/parts+mib-1)/mib*mib, maxPartSize,
+			)
+		}
+	}
+}
+
+// bodySize returns the size of the body of in if it is known.
+func bodySize(in *s3.PutObjectInput) int64 {
+	if s, ok := in.Body.(io.Seeker); ok {
+		cur, err := s.Seek(0, io.SeekCurrent)
+		if err != nil {
+			return 0
+		}
+		end, err := s.Seek(0, io.SeekEnd)
+		if _, serr := s.Seek(cur, io.SeekStart); err != nil || serr != nil {
+			return 0
+		}
+		return end - cur
+	}
+	return aws.ToInt64(in.ContentLength)
+}
+
+type multipartInfo struct {
+	Key       string     `json:"key"`
+	UploadID  string     `json:"uploadId"`
+	Initiated *time.Time `json:"initiated,omitempty"`
+}
+
+// abortUploads aborts the multipart uploads under the prefix that were
+// never completed, or only those of the given keys. Uploads started less
+// than --older-than ago may still be running and are kept. With --dry-run
+// they are only listed.
+func (u *Uploader) abortUploads(keys []string) error {
+	cutoff := u.now().Add(-u.OlderThan)
+	var found []multipartInfo
+	in := &s3.ListMultipartUploadsInput{
+		Bucket: &u.Bucket,
+		Prefix: aws.String(u.keyPrefix()),
+	}
+	for {
+		out, err := u.listMultipartUploads(in)
+		if err != nil {
+			return err
+		}
+		for _, up := range out.Uploads {
+			if len(keys) > 0 && !slices.Contains(keys, *up.Key) ||
+				up.Initiated != nil && up.Initiated.After(cutoff) {
+				continue
+			}
+			found = append(found, multipartInfo{
+				Key:       *up.Key,
+				UploadID:  *up.UploadId,
+				Initiated: up.Initiated,
+			})
+		}
+		if !aws.ToBool(out.IsTruncated) {
+			break
+		}
+		in.KeyMarker = out.NextKeyMarker
+		in.UploadIdMarker = out.NextUploadIdMarker
+	}
+
+	for _, up := range found {
+		if err := u.printMultipart(up); err != nil {
+			return err
+		}
+		if u.DryRun {
+			continue
+		}
+		_, err := u.abortMultipartUpload(&s3.AbortMultipartUploadInput{
+			Bucket:   &u.Bucket,
+			Key:      &up.Key,
+			UploadId: &up.UploadID,
+		})
+		if err != nil && !hasErrorCode(err, "NoSuchUpload") {
+			return fmt.Errorf("error aborting upload of %s: %w", up.Key, err)
+		}
+	}
+
+	if u.DryRun {
+		u.logf("%d uploads would be aborted", len(found))
+	} else {
+		u.logf("aborted %d uploads", len(found))
+	}
+	return nil
+}
+
+func (u *Uploader) printMultipart(up multipartInfo) error {
+	if u.JSON {
+		return u.printJSON(up)
+	}
+	var initiated string
+	if up.Initiated != nil {
+		initiated = up.Initiated.Format(time.RFC3339)
+	}
+	_, err := u.println(up.Key + "\t" + up.UploadID + "\t" + initiated)
+	return err
+}
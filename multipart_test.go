@@ -0,0 +1,238 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	s3manager "github.com/aws/aws-sdk-go-v2/feature/s3/manager"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	s3types "github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/aws/smithy-go"
+	"gotest.tools/v3/assert"
+)
+
+func TestParseSize(t *testing.T) {
+	t.Parallel()
+	for in, want := range map[string]int64{
+		"1048576": 1 << 20,
+		"64MiB":   64 << 20,
+		"64M":     64 << 20,
+		"64mb":    64 << 20,
+		"2G":      2 << 30,
+		"1k":      1 << 10,
+		"100B":    100,
+	} {
+		got, err := parseSize(in)
+		assert.NilError(t, err, in)
+		assert.Equal(t, got, want, in)
+	}
+	for _, in := range []string{"", "MiB", "-5M", "5X", "5MM", "1.5G"} {
+		_, err := parseSize(in)
+		assert.ErrorContains(t, err, "bad size", in)
+	}
+}
+
+func TestUploadOptions(t *testing.T) {
+	tests := []struct {
+		name     string
+		partSize int64
+		maxParts int
+		size     int64
+		want     int64
+	}{
+		{"default", 0, 0, 1 << 30, s3manager.DefaultUploadPartSize},
+		{"configured", 64 << 20, 0, 1 << 30, 64 << 20},
+		{"unknown size", 0, 0, 0, s3manager.DefaultUploadPartSize},
+		{"grown", 0, 0, 100 << 30, 11 << 20},
+		{"max parts", 0, 10, 100 << 20, 10 << 20},
+		{"rounded", 0, 2, 10<<20 + 1, 6 << 20},
+		{"capped", 0, 1, 6 << 30, maxPartSize},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := newTestRun(t)
+			r.Uploader.PartSize = tt.partSize
+			r.Uploader.MaxParts = tt.maxParts
+			r.Uploader.Concurrency = 3
+			r.Uploader.LeaveParts = true
+			m := s3manager.NewUploader(nil, r.Uploader.uploadOptions(tt.size))
+
+			assert.Equal(t, m.PartSize, tt.want)
+			assert.Equal(t, m.Concurrency, 3)
+			assert.Assert(t, m.LeavePartsOnError)
+		})
+	}
+}
+
+func TestPutObjectTooManyParts(t *testing.T) {
+	r := newTestRun(t)
+	r.Uploader.PutObject = nil
+	r.Uploader.Client = new(s3Client)
+	r.Uploader.MaxParts = 1
+	in := r.Uploader.putInput("hash/big", io.MultiReader())
+	in.ContentLength = aws.Int64(6 << 30)
+
+	_, err := r.Uploader.putObject(in)
+
+	assert.ErrorIs(t, err, errTooManyParts)
+	assert.ErrorContains(t, err, "hash/big is 6.0 GiB")
+	assert.Equal(t, len(r.Uploader.Client._CreateMultipartUpload_Calls()), 0)
+}
+
+func TestBodySize(t *testing.T) {
+	t.Parallel()
+	body := bytes.NewReader(mockFileData)
+	_, _ = body.Seek(2, io.SeekStart)
+
+	assert.Equal(t, bodySize(&s3.PutObjectInput{Body: body}), int64(6))
+	n, _ := body.Seek(0, io.SeekCurrent)
+	assert.Equal(t, n, int64(2))
+
+	assert.Equal(t, bodySize(&s3.PutObjectInput{
+		Body:          io.MultiReader(body),
+		ContentLength: aws.Int64(42),
+	}), int64(42))
+}
+
+func TestRunMultipartFlags(t *testing.T) {
+	tests := []struct {
+		args []string
+		env  map[string]string
+		err  error
+	}{
+		{args: []string{"--part-size", "1M"}, err: errBadPartSize},
+		{args: []string{"--part-size", "6G"}, err: errBadPartSize},
+		{args: []string{"--max-parts", "10001"}, err: errBadMaxParts},
+		{args: []string{"--concurrency", "0"}, err: errBadConcurrency},
+		{
+			env: map[string]string{"S3SHARE_PART_SIZE": "lots"},
+			err: errBadPartSize,
+		},
+		{
+			env: map[string]string{"S3SHARE_MAX_PARTS": "0"},
+			err: errBadMaxParts,
+		},
+		{args: []string{"--part-size", "16MiB", "--max-parts", "100"}},
+	}
+	for _, tt := range tests {
+		t.Run(strings.Join(tt.args, " "), func(t *testing.T) {
+			r := newTestRun(t)
+			args := append([]string{"s3share"}, tt.args...)
+			r.Uploader.Args = &args
+			*r.Uploader.Args = append(*r.Uploader.Args, "somefile")
+			r.Uploader.Getenv = func(name string) string {
+				if name == "S3SHARE_BUCKET" {
+					return "somebucket"
+				}
+				return tt.env[name]
+			}
+
+			err := run(r.Uploader)
+
+			if tt.err != nil {
+				assert.ErrorIs(t, err, tt.err)
+				return
+			}
+			assert.NilError(t, err)
+			assert.Equal(t, r.Uploader.PartSize, int64(16<<20))
+			assert.Equal(t, r.Uploader.MaxParts, 100)
+		})
+	}
+}
+
+var (
+	abortOld = time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	abortNew = time.Date(2024, 1, 2, 3, 0, 0, 0, time.UTC)
+)
+
+func newAbortRun(t *testing.T) *testRun {
+	r := newTestRun(t)
+	r.Uploader.Now = func() time.Time { return abortNew.Add(time.Minute) }
+	r.Uploader.Client = new(s3Client)
+	r.Uploader.Client._ListMultipartUploads_Do(func(
+		_ context.Context,
+		in *s3.ListMultipartUploadsInput,
+		_ ...func(*s3.Options),
+	) (*s3.ListMultipartUploadsOutput, error) {
+		if in.KeyMarker == nil {
+			return &s3.ListMultipartUploadsOutput{
+				Uploads: []s3types.MultipartUpload{{
+					Key:       aws.String("a/one"),
+					UploadId:  aws.String("1"),
+					Initiated: &abortOld,
+				}},
+				IsTruncated:        aws.Bool(true),
+				NextKeyMarker:      aws.String("a/one"),
+				NextUploadIdMarker: aws.String("1"),
+			}, nil
+		}
+		return &s3.ListMultipartUploadsOutput{
+			Uploads: []s3types.MultipartUpload{{
+				Key:       aws.String("b/two"),
+				UploadId:  aws.String("2"),
+				Initiated: &abortNew,
+			}},
+		}, nil
+	})
+	return r
+}
+
+func abortedKeys(c *s3Client) []string {
+	var keys []string
+	for _, call := range c._AbortMultipartUpload_Calls() {
+		keys = append(keys, *call.Params.Key)
+	}
+	return keys
+}
+
+func TestAbortUploads(t *testing.T) {
+	r := newAbortRun(t)
+	r.Uploader.Client._AbortMultipartUpload_Return(
+		&s3.AbortMultipartUploadOutput{}, nil,
+	)
+
+	err := r.Uploader.abortUploads(nil)
+
+	assert.NilError(t, err)
+	assert.DeepEqual(t, abortedKeys(r.Uploader.Client), []string{
+		"a/one", "b/two",
+	})
+	assert.DeepEqual(t, r.Stdout, []string{
+		"a/one\t1\t2024-01-01T00:00:00Z",
+		"b/two\t2\t2024-01-02T03:00:00Z",
+	})
+	assert.DeepEqual(t, r.Stderr, []string{"aborted 2 uploads"})
+	calls := r.Uploader.Client._ListMultipartUploads_Calls()
+	assert.Equal(t, *calls[1].Params.UploadIdMarker, "1")
+}
+
+func TestRunAbortOlderThanDryRun(t *testing.T) {
+	r := newAbortRun(t)
+	r.Uploader.Args = &[]string{
+		"s3share", "abort", "--older-than", "1h", "--dry-run",
+	}
+
+	err := run(r.Uploader)
+
+	assert.NilError(t, err)
+	assert.Equal(t, len(abortedKeys(r.Uploader.Client)), 0)
+	assert.DeepEqual(t, r.Stdout, []string{"a/one\t1\t2024-01-01T00:00:00Z"})
+	assert.DeepEqual(t, r.Stderr, []string{"1 uploads would be aborted"})
+}
+
+func TestAbortUploadsKeys(t *testing.T) {
+	r := newAbortRun(t)
+	r.Uploader.Client._AbortMultipartUpload_Return(
+		nil, &smithy.GenericAPIError{Code: "NoSuchUpload"},
+	)
+
+	err := r.Uploader.abortUploads([]string{"b/two"})
+
+	assert.NilError(t, err)
+	assert.DeepEqual(t, abortedKeys(r.Uploader.Client), []string{"b/two"})
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"time"
+)
+
+// progressInterval is how often the progress line is redrawn at most.
+const progressInterval = 100 * time.Millisecond
+
+const progressBarWidth = 20
+
+// progress draws a status line on standard error while a file is read
+// through it, such as while hashing or uploading it. A nil progress draws
+// nothing, so callers need not check whether progress is enabled.
+type progress struct {
+	u     *Uploader
+	label string
+	total int64
+	done  int64
+	start time.Time
+	drawn time.Time
+}
+
+// newProgress returns a progress line for reading total bytes, or 0 if
+// the total is unknown, or nil if progress is not shown.
+func (u *Uploader) newProgress(label string, total int64) *progress {
+	if !u.Progress {
+		return nil
+	}
+	return &progress{u: u, label: label, total: total, start: u.now()}
+}
+
+// reader returns r counting what is read from it towards p. If r can seek,
+// so can the returned reader, which the SDK needs to retry requests.
+func (p *progress) reader(r io.Reader) io.Reader {
+	if p == nil {
+		return r
+	}
+	pr := &progressReader{Reader: r, p: p}
+	if s, ok := r.(io.Seeker); ok {
+		return &progressReadSeeker{pr, s}
+	}
+	return pr
+}
+
+func (p *progress) set(done int64) {
+	p.done = done
+	if now := p.u.now(); now.Sub(p.drawn) >= progressInterval {
+		p.drawn = now
+		_, _ = p.u.eprint("\r" + p.line(now) + "\x1b[K")
+	}
+}
+
+// finish clears the progress line.
+func (p *progress) finish() {
+	if p != nil && !p.drawn.IsZero() {
+		_, _ = p.u.eprint("\r\x1b[K")
+	}
+}
+
+func (p *progress) line(now time.Time) string {
+	var rate int64
+	if elapsed := now.Sub(p.start).Seconds(); elapsed > 0 {
+		rate = int64(float64(p.done) / elapsed)
+	}
+	label := p.label
+	if len(label) > 24 {
+		label = label[:23] + "…"
+	}
+	if p.total <= 0 {
+		return fmt.Sprintf("%s %s %s/s",
+			label, formatSize(p.done), formatSize(rate))
+	}
+
+	done := min(p.done, p.total)
+	fill := int(done * progressBarWidth / p.total)
+	bar := strings.Repeat("=", fill) +
+		strings.Repeat(" ", progressBarWidth-fill)
+	eta := "--"
+	if rate > 0 {
+		eta = (time.Duration((p.total-done)/rate) * time.Second).String()
+	}
+	return fmt.Sprintf("%s [%s] %3d%% %s/%s %s/s ETA %s",
+		label, bar, done*100/p.total,
+		formatSize(done), formatSize(p.total), formatSize(rate), eta)
+}
+
+type progressReader struct {
+	io.Reader
+	p *progress
+}
+
+func (r *progressReader) Read(b []byte) (int, error) {
+	n, err := r.Reader.Read(b)
+	r.p.set(r.p.done + int64(n))
+	return n, err
+}
+
+type progressReadSeeker struct {
+	*progressReader
+	s io.Seeker
+}
+
+func (r *progressReadSeeker) Seek(offset int64, whence int) (int64, error) {
+	n, err := r.s.Seek(offset, whence)
+	if err == nil {
+		r.p.done = n
+	}
+	return n, err
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	s3manager "github.com/aws/aws-sdk-go-v2/feature/s3/manager"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"gotest.tools/v3/assert"
+)
+
+// newProgressRun returns a run drawing progress to its Progress buffer,
+// with a clock advancing a second each time it is read.
+func newProgressRun(t *testing.T) (*testRun, *strings.Builder) {
+	r := newTestRun(t)
+	var out strings.Builder
+	r.Uploader.Eprint = func(args ...any) (int, error) {
+		return fmt.Fprint(&out, args...)
+	}
+	r.Uploader.IsTerminal = func() bool { return true }
+	var mu sync.Mutex
+	clock := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	r.Uploader.Now = func() time.Time {
+		mu.Lock()
+		defer mu.Unlock()
+		clock = clock.Add(time.Second)
+		return clock
+	}
+	r.Uploader.PutObject = func(
+		in *s3.PutObjectInput,
+	) (*s3manager.UploadOutput, error) {
+		_, err := io.Copy(io.Discard, in.Body)
+		return &s3manager.UploadOutput{}, err
+	}
+	return r, &out
+}
+
+func TestProgressLine(t *testing.T) {
+	t.Parallel()
+	start := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	p := &progress{
+		label: "uploading file.bin",
+		total: 4 << 20,
+		done:  1 << 20,
+		start: start,
+	}
+
+	assert.Equal(t, p.line(start.Add(2*time.Second)),
+		"uploading file.bin [=====               ]  25% "+
+			"1.0 MiB/4.0 MiB 512.0 KiB/s ETA 6s")
+
+	p.total = 0
+	assert.Equal(t, p.line(start.Add(2*time.Second)),
+		"uploading file.bin 1.0 MiB 512.0 KiB/s")
+
+	p.label = "hashing a-very-long-file-name.tar.gz"
+	assert.Equal(t, p.line(start.Add(2*time.Second)),
+		"hashing a-very-long-fil… 1.0 MiB 512.0 KiB/s")
+}
+
+func TestProgressReaderSeeks(t *testing.T) {
+	r, out := newProgressRun(t)
+	r.Uploader.Progress = true
+	p := r.Uploader.newProgress("reading", 8)
+	rd := p.reader(bytes.NewReader(mockFileData))
+
+	b, err := io.ReadAll(rd)
+	assert.NilError(t, err)
+	assert.Equal(t, string(b), string(mockFileData))
+	assert.Equal(t, p.done, int64(8))
+
+	_, err = rd.(io.Seeker).Seek(2, io.SeekStart)
+	assert.NilError(t, err)
+	assert.Equal(t, p.done, int64(2))
+
+	p.finish()
+	assert.Assert(t, strings.HasSuffix(out.String(), "\r\x1b[K"))
+}
+
+func TestProgressNil(t *testing.T) {
+	r := newTestRun(t)
+	p := r.Uploader.newProgress("reading", 8)
+	rd := bytes.NewReader(mockFileData)
+
+	assert.Assert(t, p == nil)
+	assert.Equal(t, p.reader(rd), io.Reader(rd))
+	p.finish()
+}
+
+func TestRunPutProgress(t *testing.T) {
+	tests := []struct {
+		name     string
+		args     []string
+		terminal bool
+		want     bool
+	}{
+		{"terminal", nil, true, true},
+		{"not terminal", nil, false, false},
+		{"quiet", []string{"--quiet"}, true, false},
+		{"jobs", []string{"--jobs", "2", "a", "b"}, true, false},
+		{"jobs one file", []string{"--jobs", "2"}, true, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r, out := newProgressRun(t)
+			r.Uploader.Args = &[]string{"s3share", "somefile"}
+			*r.Uploader.Args = append(*r.Uploader.Args, tt.args...)
+			r.Uploader.IsTerminal = func() bool { return tt.terminal }
+			r.Uploader.UploadFile = nil
+			if len(tt.args) > 2 {
+				// The mocks of a test run are not safe for parallel use.
+				r.Uploader.UploadFile = func(string) (string, error) {
+					return "", nil
+				}
+			}
+
+			err := run(r.Uploader)
+
+			assert.NilError(t, err)
+			assert.Equal(t, r.Uploader.Progress, tt.want)
+			if !tt.want {
+				assert.Equal(t, out.String(), "")
+				return
+			}
+			assert.Assert(t, strings.Contains(out.String(),
+				"\rhashing somefile [====================] 100% "))
+			assert.Assert(t, strings.Contains(out.String(),
+				"\ruploading somefile [====================] 100% "))
+			assert.Assert(t, strings.HasSuffix(out.String(), "\r\x1b[K"))
+		})
+	}
+}
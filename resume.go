@@ -0,0 +1,270 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"path/filepath"
+	"slices"
+	"sync"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	s3manager "github.com/aws/aws-sdk-go-v2/feature/s3/manager"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	s3types "github.com/aws/aws-sdk-go-v2/service/s3/types"
+)
+
+// resumeState is what is kept on disk about a resumable upload.
+type resumeState struct {
+	Bucket   string       `json:"bucket"`
+	Key      string       `json:"key"`
+	UploadID string       `json:"uploadId"`
+	PartSize int64        `json:"partSize"`
+	Parts    []resumePart `json:"parts"`
+}
+
+type resumePart struct {
+	Number int32  `json:"number"`
+	ETag   string `json:"etag"`
+	Size   int64  `json:"size"`
+}
+
+// putResumable uploads body, of the given size, as a multipart upload whose
+// ID and finished parts are saved to a state file as they complete. Keys
+// are derived from the content hash, so uploading the same file again
+// after a failure finds the state, asks S3 which parts it already has and
+// sends only the rest.
+func (u *Uploader) putResumable(
+	in *s3.PutObjectInput, body io.ReadSeeker, size int64,
+) (*s3manager.UploadOutput, error) {
+	m := s3manager.NewUploader(u.Client, u.uploadOptions(size))
+	path, err := u.statePath(*in.Key)
+	if err != nil {
+		return nil, err
+	}
+
+	st, done, err := u.loadState(path, in)
+	if err != nil {
+		return nil, err
+	}
+	if st == nil {
+		out, err := u.createMultipartUpload(multipartInput(in))
+		if err != nil {
+			return nil, err
+		}
+		st = &resumeState{
+			Bucket:   u.Bucket,
+			Key:      *in.Key,
+			UploadID: *out.UploadId,
+			PartSize: m.PartSize,
+		}
+		if err := u.saveState(path, st); err != nil {
+			return nil, err
+		}
+	} else if len(done) > 0 {
+		u.logf("resuming upload of %s: %d parts already uploaded",
+			*in.Key, len(done))
+	}
+
+	var (
+		mu   sync.Mutex
+		wg   sync.WaitGroup
+		errs []error
+	)
+	// The parts S3 already has are kept before any are sent, since the
+	// goroutines sending the rest add to the state as they finish.
+	st.Parts = st.Parts[:0]
+	for off := int64(0); off < size; off += st.PartSize {
+		num := int32(off/st.PartSize) + 1
+		n := min(st.PartSize, size-off)
+		if p, ok := done[num]; ok && aws.ToInt64(p.Size) == n {
+			st.Parts = append(st.Parts, resumePart{num, *p.ETag, n})
+		} else {
+			delete(done, num)
+		}
+	}
+
+	sem := make(chan struct{}, max(m.Concurrency, 1))
+	for off := int64(0); off < size; off += st.PartSize {
+		num := int32(off/st.PartSize) + 1
+		n := min(st.PartSize, size-off)
+		if _, ok := done[num]; ok {
+			continue
+		}
+
+		sem <- struct{}{}
+		mu.Lock()
+		failed := len(errs) > 0
+		mu.Unlock()
+		if failed {
+			<-sem
+			break
+		}
+
+		buf := make([]byte, n)
+		_, err := body.Seek(off, io.SeekStart)
+		if err == nil {
+			_, err = io.ReadFull(body, buf)
+		}
+		if err != nil {
+			<-sem
+			mu.Lock()
+			errs = append(errs, err)
+			mu.Unlock()
+			break
+		}
+		wg.Add(1)
+		go func() {
+			defer func() { <-sem; wg.Done() }()
+			out, err := u.uploadPart(&s3.UploadPartInput{
+				Bucket:               &u.Bucket,
+				Key:                  in.Key,
+				UploadId:             &st.UploadID,
+				PartNumber:           aws.Int32(num),
+				Body:                 bytes.NewReader(buf),
+				SSECustomerAlgorithm: in.SSECustomerAlgorithm,
+				SSECustomerKey:       in.SSECustomerKey,
+				SSECustomerKeyMD5:    in.SSECustomerKeyMD5,
+			})
+			mu.Lock()
+			defer mu.Unlock()
+			if err != nil {
+				errs = append(errs, fmt.Errorf("part %d: %w", num, err))
+				return
+			}
+			st.Parts = append(st.Parts, resumePart{num, *out.ETag, n})
+			if err := u.saveState(path, st); err != nil {
+				errs = append(errs, err)
+			}
+		}()
+	}
+	wg.Wait()
+	if len(errs) > 0 {
+		u.logf("upload of %s interrupted; run again to resume it", *in.Key)
+		return nil, errors.Join(errs...)
+	}
+
+	slices.SortFunc(st.Parts, func(a, b resumePart) int {
+		return int(a.Number - b.Number)
+	})
+	parts := make([]s3types.CompletedPart, len(st.Parts))
+	for i, p := range st.Parts {
+		parts[i] = s3types.CompletedPart{
+			ETag:       aws.String(p.ETag),
+			PartNumber: aws.Int32(p.Number),
+		}
+	}
+	out, err := u.completeMultipartUpload(&s3.CompleteMultipartUploadInput{
+		Bucket:          &u.Bucket,
+		Key:             in.Key,
+		UploadId:        &st.UploadID,
+		MultipartUpload: &s3types.CompletedMultipartUpload{Parts: parts},
+
+		SSECustomerAlgorithm: in.SSECustomerAlgorithm,
+		SSECustomerKey:       in.SSECustomerKey,
+		SSECustomerKeyMD5:    in.SSECustomerKeyMD5,
+	})
+	if err != nil {
+		return nil, err
+	}
+	if err := u.removeFile(path); err != nil {
+		return nil, fmt.Errorf("error removing upload state: %w", err)
+	}
+	return &s3manager.UploadOutput{
+		ETag:     out.ETag,
+		Key:      in.Key,
+		Location: aws.ToString(out.Location),
+		UploadID: st.UploadID,
+	}, nil
+}
+
+// loadState reads the state of an earlier upload of in, if there is one
+// that S3 still knows about, along with the parts S3 has received.
+func (u *Uploader) loadState(
+	path string, in *s3.PutObjectInput,
+) (*resumeState, map[int32]s3types.Part, error) {
+	b, err := u.readFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil, nil
+	} else if err != nil {
+		return nil, nil, fmt.Errorf("error reading upload state: %w", err)
+	}
+	var st resumeState
+	if err := json.Unmarshal(b, &st); err != nil ||
+		st.Bucket != u.Bucket || st.Key != *in.Key || st.PartSize <= 0 {
+		return nil, nil, nil
+	}
+
+	done := make(map[int32]s3types.Part)
+	listIn := &s3.ListPartsInput{
+		Bucket:   &u.Bucket,
+		Key:      in.Key,
+		UploadId: &st.UploadID,
+	}
+	for {
+		out, err := u.listParts(listIn)
+		if hasErrorCode(err, "NoSuchUpload") {
+			// The upload was completed or aborted since.
+			return nil, nil, nil
+		} else if err != nil {
+			return nil, nil, err
+		}
+		for _, p := range out.Parts {
+			done[*p.PartNumber] = p
+		}
+		if !aws.ToBool(out.IsTruncated) {
+			return &st, done, nil
+		}
+		listIn.PartNumberMarker = out.NextPartNumberMarker
+	}
+}
+
+func (u *Uploader) saveState(path string, st *resumeState) error {
+	b, err := json.Marshal(st)
+	if err != nil {
+		return err
+	}
+	if err := u.saveFile(path, b); err != nil {
+		return fmt.Errorf("error saving upload state: %w", err)
+	}
+	return nil
+}
+
+// statePath returns the file the state of uploading key is kept in.
+func (u *Uploader) statePath(key string) (string, error) {
+	dir := u.StateDir
+	if dir == "" {
+		cache, err := u.cacheDir()
+		if err != nil {
+			return "", fmt.Errorf("error finding upload state: %w", err)
+		}
+		dir = filepath.Join(cache, "s3share", "uploads")
+	}
+	sum := sha256.Sum256([]byte(u.Bucket + "/" + key))
+	return filepath.Join(dir, hex.EncodeToString(sum[:])+".json"), nil
+}
+
+// multipartInput starts a multipart upload with the settings of in.
+func multipartInput(in *s3.PutObjectInput) *s3.CreateMultipartUploadInput {
+	return &s3.CreateMultipartUploadInput{
+		Bucket:             in.Bucket,
+		Key:                in.Key,
+		ACL:                in.ACL,
+		Metadata:           in.Metadata,
+		CacheControl:       in.CacheControl,
+		ContentDisposition: in.ContentDisposition,
+		ContentEncoding:    in.ContentEncoding,
+		ContentType:        in.ContentType,
+
+		ServerSideEncryption: in.ServerSideEncryption,
+		SSEKMSKeyId:          in.SSEKMSKeyId,
+		SSECustomerAlgorithm: in.SSECustomerAlgorithm,
+		SSECustomerKey:       in.SSECustomerKey,
+		SSECustomerKeyMD5:    in.SSECustomerKeyMD5,
+	}
+}
@@ -0,0 +1,253 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	s3types "github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/aws/smithy-go"
+	"gotest.tools/v3/assert"
+)
+
+// resumeSize is uploaded in three parts, the last of them short.
+const resumeSize = 2*minPartSize + 1
+
+type resumeRun struct {
+	*testRun
+	States map[string][]byte
+}
+
+// stateFile returns the state saved for hash/big, if any.
+func (r *resumeRun) stateFile(t *testing.T) ([]byte, bool) {
+	t.Helper()
+	path, err := r.Uploader.statePath("hash/big")
+	assert.NilError(t, err)
+	b, ok := r.States[path]
+	return b, ok
+}
+
+func newResumeRun(t *testing.T) (*resumeRun, *s3.PutObjectInput) {
+	r := &resumeRun{testRun: newTestRun(t), States: map[string][]byte{}}
+	r.Uploader.Bucket = "somebucket"
+	r.Uploader.PutObject = nil
+	r.Uploader.Resume = true
+	r.Uploader.PartSize = minPartSize
+	r.Uploader.CacheDir = func() (string, error) { return "/cache", nil }
+	r.Uploader.ReadFile = func(name string) ([]byte, error) {
+		if b, ok := r.States[name]; ok {
+			return b, nil
+		}
+		return nil, fs.ErrNotExist
+	}
+	r.Uploader.SaveFile = func(name string, b []byte) error {
+		r.States[name] = b
+		return nil
+	}
+	r.Uploader.RemoveFile = func(name string) error {
+		if _, ok := r.States[name]; !ok {
+			return fs.ErrNotExist
+		}
+		delete(r.States, name)
+		return nil
+	}
+	c := new(s3Client)
+	r.Uploader.Client = c
+	c._CreateMultipartUpload_Return(&s3.CreateMultipartUploadOutput{
+		UploadId: aws.String("upload"),
+	}, nil)
+	c._UploadPart_Do(func(
+		_ context.Context,
+		in *s3.UploadPartInput,
+		_ ...func(*s3.Options),
+	) (*s3.UploadPartOutput, error) {
+		return &s3.UploadPartOutput{
+			ETag: aws.String(fmt.Sprint("etag", *in.PartNumber)),
+		}, nil
+	})
+	c._CompleteMultipartUpload_Return(
+		&s3.CompleteMultipartUploadOutput{}, nil,
+	)
+	in := r.Uploader.putInput("hash/big", bytes.NewReader(
+		make([]byte, resumeSize),
+	))
+	return r, in
+}
+
+func uploadedParts(c *s3Client) []int32 {
+	var nums []int32
+	for _, call := range c._UploadPart_Calls() {
+		nums = append(nums, *call.Params.PartNumber)
+	}
+	slices.Sort(nums)
+	return nums
+}
+
+func TestPutResumable(t *testing.T) {
+	r, in := newResumeRun(t)
+	c := r.Uploader.Client
+
+	_, err := r.Uploader.putObject(in)
+
+	assert.NilError(t, err)
+	assert.Equal(t, len(c._ListParts_Calls()), 0)
+	assert.Equal(t, len(uploadedParts(c)), 3)
+	parts := c._CompleteMultipartUpload_Calls()[0].Params.
+		MultipartUpload.Parts
+	assert.Equal(t, len(parts), 3)
+	for i, p := range parts {
+		assert.Equal(t, *p.PartNumber, int32(i+1))
+		assert.Equal(t, *p.ETag, fmt.Sprint("etag", i+1))
+	}
+	_, ok := r.stateFile(t)
+	assert.Assert(t, !ok)
+}
+
+func TestPutResumableResumes(t *testing.T) {
+	r, in := newResumeRun(t)
+	c := r.Uploader.Client
+	path, err := r.Uploader.statePath("hash/big")
+	assert.NilError(t, err)
+	err = r.Uploader.saveState(path, &resumeState{
+		Bucket:   "somebucket",
+		Key:      "hash/big",
+		UploadID: "old",
+		PartSize: minPartSize,
+	})
+	assert.NilError(t, err)
+	c._ListParts_Return(&s3.ListPartsOutput{
+		Parts: []s3types.Part{{
+			PartNumber: aws.Int32(1),
+			ETag:       aws.String("etag1"),
+			Size:       aws.Int64(minPartSize),
+		}},
+		IsTruncated:          aws.Bool(true),
+		NextPartNumberMarker: aws.String("1"),
+	}, nil)
+	c._ListParts_Return(&s3.ListPartsOutput{
+		Parts: []s3types.Part{{
+			PartNumber: aws.Int32(3),
+			ETag:       aws.String("etag3"),
+			Size:       aws.Int64(3),
+		}},
+	}, nil)
+
+	_, err = r.Uploader.putObject(in)
+
+	assert.NilError(t, err)
+	assert.Equal(t, len(c._CreateMultipartUpload_Calls()), 0)
+	assert.Equal(t, *c._ListParts_Calls()[1].Params.PartNumberMarker, "1")
+	// The listed third part has the wrong size, so it is sent again.
+	assert.DeepEqual(t, uploadedParts(c), []int32{2, 3})
+	assert.Equal(t, *c._UploadPart_Calls()[0].Params.UploadId, "old")
+	complete := c._CompleteMultipartUpload_Calls()[0].Params
+	assert.Equal(t, *complete.UploadId, "old")
+	assert.Equal(t, len(complete.MultipartUpload.Parts), 3)
+	assert.DeepEqual(t, r.Stderr, []string{
+		"resuming upload of hash/big: 2 parts already uploaded",
+	})
+}
+
+// Parts S3 already has may come after one that is missing, which is sent
+// while they are being kept.
+func TestPutResumableMissingFirst(t *testing.T) {
+	r, in := newResumeRun(t)
+	c := r.Uploader.Client
+	path, err := r.Uploader.statePath("hash/big")
+	assert.NilError(t, err)
+	err = r.Uploader.saveState(path, &resumeState{
+		Bucket:   "somebucket",
+		Key:      "hash/big",
+		UploadID: "old",
+		PartSize: minPartSize,
+	})
+	assert.NilError(t, err)
+	c._ListParts_Return(&s3.ListPartsOutput{
+		Parts: []s3types.Part{{
+			PartNumber: aws.Int32(2),
+			ETag:       aws.String("etag2"),
+			Size:       aws.Int64(minPartSize),
+		}, {
+			PartNumber: aws.Int32(3),
+			ETag:       aws.String("etag3"),
+			Size:       aws.Int64(1),
+		}},
+	}, nil)
+
+	_, err = r.Uploader.putObject(in)
+
+	assert.NilError(t, err)
+	assert.DeepEqual(t, uploadedParts(c), []int32{1})
+	parts := c._CompleteMultipartUpload_Calls()[0].Params.
+		MultipartUpload.Parts
+	assert.Equal(t, len(parts), 3)
+	for i, p := range parts {
+		assert.Equal(t, *p.PartNumber, int32(i+1))
+		assert.Equal(t, *p.ETag, fmt.Sprint("etag", i+1))
+	}
+}
+
+func TestPutResumableRestarts(t *testing.T) {
+	r, in := newResumeRun(t)
+	c := r.Uploader.Client
+	path, err := r.Uploader.statePath("hash/big")
+	assert.NilError(t, err)
+	err = r.Uploader.saveState(path, &resumeState{
+		Bucket:   "somebucket",
+		Key:      "hash/big",
+		UploadID: "gone",
+		PartSize: minPartSize,
+	})
+	assert.NilError(t, err)
+	c._ListParts_Return(nil, &smithy.GenericAPIError{Code: "NoSuchUpload"})
+
+	_, err = r.Uploader.putObject(in)
+
+	assert.NilError(t, err)
+	assert.Equal(t, len(c._CreateMultipartUpload_Calls()), 1)
+	assert.Equal(t, len(uploadedParts(c)), 3)
+	complete := c._CompleteMultipartUpload_Calls()[0].Params
+	assert.Equal(t, *complete.UploadId, "upload")
+}
+
+func TestPutResumableKeepsState(t *testing.T) {
+	r, in := newResumeRun(t)
+	r.Uploader.Concurrency = 1
+	c := r.Uploader.Client
+	c._UploadPart_Return(&s3.UploadPartOutput{ETag: aws.String("a")}, nil)
+	c._UploadPart_Return(nil, &smithy.GenericAPIError{Code: "SlowDown"})
+
+	_, err := r.Uploader.putObject(in)
+
+	assert.ErrorContains(t, err, "part 3")
+	assert.Equal(t, len(c._CompleteMultipartUpload_Calls()), 0)
+	assert.Equal(t, len(c._AbortMultipartUpload_Calls()), 0)
+	b, ok := r.stateFile(t)
+	assert.Assert(t, ok)
+	var st resumeState
+	assert.NilError(t, json.Unmarshal(b, &st))
+	assert.Equal(t, st.UploadID, "upload")
+	assert.DeepEqual(t, st.Parts, []resumePart{
+		{1, "etag1", minPartSize}, {2, "a", minPartSize},
+	})
+}
+
+func TestStatePath(t *testing.T) {
+	r, _ := newResumeRun(t)
+
+	path, err := r.Uploader.statePath("hash/big")
+	assert.NilError(t, err)
+	assert.Equal(t, filepath.Dir(path), "/cache/s3share/uploads")
+
+	r.Uploader.StateDir = "/state"
+	other, err := r.Uploader.statePath("hash/big")
+	assert.NilError(t, err)
+	assert.Equal(t, other, "/state/"+filepath.Base(path))
+}
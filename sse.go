@@ -0,0 +1,89 @@
+package main
+
+import (
+	"bytes"
+	"crypto/md5"
+	"encoding/base64"
+	"errors"
+	"fmt"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	s3types "github.com/aws/aws-sdk-go-v2/service/s3/types"
+)
+
+var errBadSSE = errors.New("server-side encryption must be aes256 or kms")
+var errKMSKeyID = errors.New("--kms-key-id needs --sse kms")
+var errSSEConflict = errors.New("--sse cannot be used with --sse-c-key")
+var errSSECKey = errors.New(
+	"SSE-C key must be 32 bytes, either raw or base64 encoded",
+)
+
+const (
+	sseAES256 = "aes256"
+	sseKMS    = "kms"
+)
+
+func (u *Uploader) checkSSE() error {
+	switch u.SSE {
+	case "", sseAES256, sseKMS:
+	default:
+		return fmt.Errorf("%w: %s", errBadSSE, u.SSE)
+	}
+	if u.KMSKeyID != "" && u.SSE != sseKMS {
+		return errKMSKeyID
+	}
+	if u.SSECKeyFile != "" && u.SSE != "" {
+		return errSSEConflict
+	}
+	return nil
+}
+
+// loadSSECKey reads the customer key objects are encrypted with, if SSE-C
+// is configured.
+func (u *Uploader) loadSSECKey() error {
+	if u.SSECKeyFile == "" {
+		return nil
+	}
+
+	b, err := u.readFile(u.SSECKeyFile)
+	if err != nil {
+		return fmt.Errorf("error reading SSE-C key: %w", err)
+	}
+	if len(b) != 32 {
+		b, err = base64.StdEncoding.DecodeString(string(bytes.TrimSpace(b)))
+		if err != nil || len(b) != 32 {
+			return errSSECKey
+		}
+	}
+	u.SSECKey = b
+	return nil
+}
+
+// sse returns the encryption new objects are stored with, which is left to
+// the bucket default when it is empty.
+func (u *Uploader) sse() (s3types.ServerSideEncryption, *string) {
+	switch u.SSE {
+	case sseAES256:
+		return s3types.ServerSideEncryptionAes256, nil
+	case sseKMS:
+		if u.KMSKeyID == "" {
+			return s3types.ServerSideEncryptionAwsKms, nil
+		}
+		return s3types.ServerSideEncryptionAwsKms, &u.KMSKeyID
+	default:
+		return "", nil
+	}
+}
+
+// ssec returns the algorithm, key and key digest headers that S3 needs on
+// every request that reads or writes an object encrypted with a customer
+// key, or nils if there is no key.
+func (u *Uploader) ssec() (alg, key, keyMD5 *string) {
+	if u.SSECKey == nil {
+		return nil, nil, nil
+	}
+	sum := md5.Sum(u.SSECKey)
+	return aws.String("AES256"),
+		aws.String(base64.StdEncoding.EncodeToString(u.SSECKey)),
+		aws.String(base64.StdEncoding.EncodeToString(sum[:]))
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"strings"
+	"testing"
+	"time"
+
+	v4 "github.com/aws/aws-sdk-go-v2/aws/signer/v4"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	s3types "github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"gotest.tools/v3/assert"
+)
+
+var testSSECKey = bytes.Repeat([]byte{7}, 32)
+
+func TestRunSSEFlags(t *testing.T) {
+	tests := []struct {
+		name string
+		args []string
+		err  error
+	}{
+		{"bad sse", []string{"--sse", "des"}, errBadSSE},
+		{"kms key without kms", []string{"--kms-key-id", "k"}, errKMSKeyID},
+		{"sse and sse-c", []string{"--sse", "aes256", "--sse-c-key", "k"},
+			errSSEConflict},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := newTestRun(t)
+			r.Uploader.Args = &[]string{"s3share", "file"}
+			*r.Uploader.Args = append(*r.Uploader.Args, tt.args...)
+
+			err := run(r.Uploader)
+
+			assert.ErrorIs(t, err, tt.err)
+		})
+	}
+}
+
+func TestRunSSEKMS(t *testing.T) {
+	r := newTestRun(t)
+	r.Uploader.UploadFile = nil
+	r.Uploader.Args = &[]string{
+		"s3share", "--sse", "kms", "--kms-key-id", "alias/share", "file",
+	}
+
+	err := run(r.Uploader)
+
+	assert.NilError(t, err)
+	in := r.PutObjectCalls[0]
+	assert.Equal(t, in.ServerSideEncryption, s3types.ServerSideEncryptionAwsKms)
+	assert.Equal(t, *in.SSEKMSKeyId, "alias/share")
+	assert.Assert(t, in.SSECustomerKey == nil)
+}
+
+func TestRunSSEAES256(t *testing.T) {
+	r := newTestRun(t)
+	r.Uploader.UploadFile = nil
+	r.Uploader.Args = &[]string{"s3share", "--sse", "aes256", "file"}
+
+	err := run(r.Uploader)
+
+	assert.NilError(t, err)
+	in := r.PutObjectCalls[0]
+	assert.Equal(t, in.ServerSideEncryption, s3types.ServerSideEncryptionAes256)
+	assert.Assert(t, in.SSEKMSKeyId == nil)
+}
+
+func TestLoadSSECKey(t *testing.T) {
+	encoded := base64.StdEncoding.EncodeToString(testSSECKey) + "\n"
+	tests := []struct {
+		name string
+		file string
+		err  error
+	}{
+		{"raw", string(testSSECKey), nil},
+		{"base64", encoded, nil},
+		{"short", "0123456789", errSSECKey},
+		{"bad base64", strings.Repeat("!", 44), errSSECKey},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := newTestRun(t)
+			r.Uploader.SSECKeyFile = "key"
+			r.Uploader.ReadFile = func(string) ([]byte, error) {
+				return []byte(tt.file), nil
+			}
+
+			err := r.Uploader.loadSSECKey()
+
+			if tt.err != nil {
+				assert.ErrorIs(t, err, tt.err)
+				return
+			}
+			assert.NilError(t, err)
+			assert.DeepEqual(t, r.Uploader.SSECKey, testSSECKey)
+		})
+	}
+}
+
+func TestSSECHeaders(t *testing.T) {
+	r := newTestRun(t)
+	r.Uploader.SSECKey = testSSECKey
+	// Base64 MD5 digest of testSSECKey.
+	const keyMD5 = "y4HAEFCYWuvAXWFTtA1Qpg=="
+
+	put := r.Uploader.putInput("hash/file", nil)
+	assert.Equal(t, *put.SSECustomerAlgorithm, "AES256")
+	assert.Equal(t, *put.SSECustomerKey,
+		base64.StdEncoding.EncodeToString(testSSECKey))
+	assert.Equal(t, *put.SSECustomerKeyMD5, keyMD5)
+	assert.Equal(t, put.ServerSideEncryption, s3types.ServerSideEncryption(""))
+
+	head := r.Uploader.headInput("hash/file")
+	assert.Equal(t, *head.SSECustomerKeyMD5, keyMD5)
+
+	cp := r.Uploader.copyInput("tmp/x", "hash/file")
+	assert.Equal(t, *cp.SSECustomerKeyMD5, keyMD5)
+	assert.Equal(t, *cp.CopySourceSSECustomerKeyMD5, keyMD5)
+}
+
+func TestSSECPresigned(t *testing.T) {
+	r := newTestRun(t)
+	r.Uploader.SSECKey = testSSECKey
+	r.Uploader.Expires = time.Hour
+	var got *s3.GetObjectInput
+	r.Uploader.PresignGetObject = func(
+		in *s3.GetObjectInput,
+	) (*v4.PresignedHTTPRequest, error) {
+		got = in
+		return &v4.PresignedHTTPRequest{URL: "https://presigned"}, nil
+	}
+
+	link, err := r.Uploader.objectUrl("hash/file")
+
+	assert.NilError(t, err)
+	assert.Equal(t, link, "https://presigned")
+	assert.Equal(t, *got.SSECustomerAlgorithm, "AES256")
+	assert.Equal(t, *got.SSECustomerKey,
+		base64.StdEncoding.EncodeToString(testSSECKey))
+}
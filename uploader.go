@@ -2,44 +2,135 @@ package main
 
 import (
 	"context"
+	"crypto/rsa"
 	"crypto/sha256"
-	"encoding/base64"
 	"errors"
 	"fmt"
 	"io"
+	"io/fs"
+	"net/http"
 	"os"
+	"path"
 	"path/filepath"
+	"slices"
+	"time"
 
+	"github.com/aws/aws-sdk-go-v2/aws"
+	v4 "github.com/aws/aws-sdk-go-v2/aws/signer/v4"
 	s3manager "github.com/aws/aws-sdk-go-v2/feature/s3/manager"
 	"github.com/aws/aws-sdk-go-v2/service/s3"
 	s3types "github.com/aws/aws-sdk-go-v2/service/s3/types"
 	"github.com/aws/smithy-go"
 )
 
-var errHelp = errors.New(`s3share [file]
+var errNoBucket = errors.New(
+	"no bucket set: use --bucket or S3SHARE_BUCKET.",
+)
+var errBadExpires = errors.New(
+	"expiry must be a duration between 1s and 168h.",
+)
+var errBadACL = errors.New("unknown canned ACL")
+var errBadEndpoint = errors.New("endpoint must be an http or https URL")
+var errBadBaseURL = errors.New("base URL must be an http or https URL")
+var errBadTTL = errors.New("ttl must be a positive duration such as 12h or 7d")
+var errBadURL = errors.New("URL does not point into the bucket")
+var errBadSince = errors.New("since must be a duration or a date")
+var errBadDays = errors.New("days must not be negative.")
+var errBadJobs = errors.New("jobs must be a positive number.")
+
+// defaultRegion is assumed when neither the configuration nor the bucket
+// says otherwise.
+const defaultRegion = "us-east-1"
 
-Uploads files to an S3 bucket specified in the
-environment variable S3SHARE_BUCKET.`)
-var errEnvNotSet = errors.New("S3SHARE_BUCKET environment variable not set.")
+// maxExpires is the longest lifetime S3 allows for a SigV4 presigned URL.
+const maxExpires = 7 * 24 * time.Hour
 
 type Uploader struct {
 	// Variables.
-	Args    *[]string
-	Bucket  string
-	Client  *s3Client
-	Context context.Context
+	Args         *[]string
+	AbortDays    int
+	ACL          string
+	Archive      string
+	BaseURL      string
+	Bucket       string
+	CORSOrigin   string
+	Concurrency  int
+	CacheControl string
+	CFCookies    bool
+	CFKey        *rsa.PrivateKey
+	CFKeyPairID  string
+	CFPrivateKey string
+	CFSourceIP   string
+	Compress     string
+	Client       *s3Client
+	Context      context.Context
+	Disposition  string
+	DryRun       bool
+	DualStack    bool
+	Encrypt      bool
+	Endpoint     string
+	ExpireDays   int
+	Expires      time.Duration
+	FIPS         bool
+	Jobs         int
+	JSON         bool
+	KeepGoing    bool
+	KeyTemplate  string
+	KMSKeyID     string
+	LeaveParts   bool
+	MaxParts     int
+	Name         string
+	Output       string
+	OlderThan    time.Duration
+	PartSize     int64
+	PathStyle    bool
+	Progress     bool
+	Prefix       string
+	Profile      string
+	Quiet        bool
+	Region       string
+	Resume       bool
+	Since        time.Time
+	SinglePass   bool
+	SSE          string
+	SSECKey      []byte
+	SSECKeyFile  string
+	StateDir     string
+	Stdin        io.Reader
+	Stdout       io.Writer
+	TTL          time.Duration
+	Yes          bool
 
 	// IO functions.
-	Getenv    func(string) string
-	OpenFile  func(string) (io.ReadSeekCloser, error)
-	Println   func(...any) (int, error)
-	PutObject func(*s3.PutObjectInput) (*s3manager.UploadOutput, error)
-	Stat      func(string) (os.FileInfo, error)
+	CacheDir   func() (string, error)
+	CreateTemp func() (*os.File, error)
+	Eprint     func(...any) (int, error)
+	Eprintln   func(...any) (int, error)
+	Fetch      func(string) (*http.Response, error)
+	Getenv     func(string) string
+	Hostname   func() (string, error)
+	IsTerminal func() bool
+	Now        func() time.Time
+	OpenFile   func(string) (io.ReadSeekCloser, error)
+	Println    func(...any) (int, error)
+	PutObject  func(*s3.PutObjectInput) (*s3manager.UploadOutput, error)
+	ReadFile   func(string) ([]byte, error)
+	RemoveFile func(string) error
+	SaveFile   func(string, []byte) error
+	Stat       func(string) (os.FileInfo, error)
+	Username   func() (string, error)
+	WalkDir    func(string, fs.WalkDirFunc) error
+	WriteFile  func(string, io.Reader) error
+
+	PresignGetObject func(*s3.GetObjectInput) (
+		*v4.PresignedHTTPRequest, error,
+	)
 
 	// Internal functions.
-	ObjectExists func(string) (bool, error)
-	SetupClient  func() error
-	UploadFile   func(string) (string, error)
+	ObjectExists  func(string) (bool, error)
+	RefreshExpiry func(string) error
+	SetupClient   func() error
+	UploadFile    func(string) (string, error)
 }
 
 //go:generate go run lesiw.io/moxie@latest s3Client
@@ -52,52 +143,203 @@ func (u *Uploader) uploadFile(path string) (string, error) {
 		return u.UploadFile(path)
 	}
 
-	if _, err := u.stat(path); err != nil {
-		return "", fmt.Errorf(
-			"file does not exist or cannot be read: %s",
-			path,
-		)
+	if path != "-" {
+		if fi, err := u.stat(path); err == nil && fi.IsDir() {
+			if u.Encrypt {
+				return "", fmt.Errorf("%w: %s", errEncryptDir, path)
+			}
+			return u.uploadDir(path)
+		}
+	}
+	if u.Encrypt {
+		return u.uploadEncrypted(path)
+	}
+	if u.SinglePass && !u.DryRun {
+		return u.uploadStream(path)
 	}
 
-	file, err := u.openFile(path)
+	key, file, err := u.openKeyed(path)
 	if err != nil {
 		return "", err
 	}
 	defer func() { _ = file.Close() }()
 
-	sum := sha256.New()
-	if _, err := io.Copy(sum, file); err != nil {
-		return "", fmt.Errorf("error computing file hash: %w", err)
+	return u.uploadKeyed(key, u.name(path), file)
+}
+
+// uploadKeyed uploads file, shared as name, under key unless an object
+// already exists there and returns its URL. The file is rewound before
+// uploading.
+func (u *Uploader) uploadKeyed(
+	key, name string, file io.ReadSeeker,
+) (string, error) {
+	if u.dedupes() {
+		if ok, err := u.objectExists(key); err != nil {
+			return "", err
+		} else if ok {
+			if err := u.refreshExpiry(key); err != nil {
+				return "", err
+			}
+			return u.objectUrl(key)
+		}
 	}
 
-	key := base64.RawURLEncoding.EncodeToString(
-		sum.Sum(nil),
-	) + "/" + filepath.Base(path)
-	if ok, err := u.objectExists(key); err != nil {
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
 		return "", err
-	} else if ok {
-		return objectUrl(u.Bucket, key), nil
 	}
 
-	if _, err = file.Seek(0, io.SeekStart); err != nil {
+	in := u.putInput(key, file)
+	if err := u.contentHeaders(in, name); err != nil {
+		return "", err
+	}
+	if err := u.upload(in); err != nil {
 		return "", err
 	}
 
-	_, err = u.putObject(&s3.PutObjectInput{
+	return u.objectUrl(key)
+}
+
+// upload puts an object, or with --dry-run only says that it would.
+func (u *Uploader) upload(in *s3.PutObjectInput) error {
+	if u.DryRun {
+		u.logf("would upload %s", *in.Key)
+		return nil
+	}
+
+	var size int64
+	if s, ok := in.Body.(io.Seeker); ok && u.Progress {
+		size, _ = s.Seek(0, io.SeekEnd)
+		if _, err := s.Seek(0, io.SeekStart); err != nil {
+			return err
+		}
+	}
+	p := u.newProgress("uploading "+path.Base(*in.Key), size)
+	defer p.finish()
+	in.Body = p.reader(in.Body)
+	defer u.compress(in)()
+
+	_, err := u.putObject(in)
+	return err
+}
+
+func (u *Uploader) putInput(key string, body io.Reader) *s3.PutObjectInput {
+	in := &s3.PutObjectInput{
+		Bucket: &u.Bucket,
+		Key:    &key,
+		Body:   body,
+		ACL:    u.acl(),
+	}
+	in.ServerSideEncryption, in.SSEKMSKeyId = u.sse()
+	in.SSECustomerAlgorithm, in.SSECustomerKey, in.SSECustomerKeyMD5 = u.ssec()
+	if u.TTL != 0 {
+		in.Metadata = map[string]string{expiresMeta: u.expiresAt()}
+	}
+	return in
+}
+
+func (u *Uploader) headInput(key string) *s3.HeadObjectInput {
+	in := &s3.HeadObjectInput{
 		Bucket: &u.Bucket,
 		Key:    &key,
-		Body:   file,
-		ACL:    s3types.ObjectCannedACLPublicRead,
-	})
+	}
+	in.SSECustomerAlgorithm, in.SSECustomerKey, in.SSECustomerKeyMD5 = u.ssec()
+	return in
+}
+
+// copyInput copies the object at src to dst, encrypting the copy like a new
+// upload would be.
+func (u *Uploader) copyInput(src, dst string) *s3.CopyObjectInput {
+	in := &s3.CopyObjectInput{
+		Bucket:     &u.Bucket,
+		Key:        &dst,
+		CopySource: aws.String(escapeKey(u.Bucket + "/" + src)),
+		ACL:        u.acl(),
+	}
+	in.ServerSideEncryption, in.SSEKMSKeyId = u.sse()
+	in.SSECustomerAlgorithm, in.SSECustomerKey, in.SSECustomerKeyMD5 = u.ssec()
+	in.CopySourceSSECustomerAlgorithm, in.CopySourceSSECustomerKey,
+		in.CopySourceSSECustomerKeyMD5 = u.ssec()
+	return in
+}
+
+// openKeyed opens the file at path and hashes it to find the key it is
+// shared under. A path of - reads standard input, which is spooled to a
+// temporary file so that it can be rewound for the upload. The caller is
+// responsible for closing the returned file.
+func (u *Uploader) openKeyed(path string) (string, io.ReadSeekCloser, error) {
+	if path == "-" {
+		return u.openStdin()
+	}
+
+	fi, err := u.stat(path)
 	if err != nil {
-		return "", err
+		return "", nil, fmt.Errorf(
+			"file does not exist or cannot be read: %s",
+			path,
+		)
+	}
+
+	file, err := u.openFile(path)
+	if err != nil {
+		return "", nil, err
 	}
 
-	return objectUrl(u.Bucket, key), nil
+	p := u.newProgress("hashing "+filepath.Base(path), fi.Size())
+	defer p.finish()
+	sum := sha256.New()
+	if _, err := io.Copy(sum, p.reader(file)); err != nil {
+		_ = file.Close()
+		return "", nil, fmt.Errorf("error computing file hash: %w", err)
+	}
+
+	return u.objectKey(sum.Sum(nil), u.name(path)), file, nil
 }
 
-func objectUrl(bucket, key string) string {
-	return fmt.Sprintf("https://%s.s3.amazonaws.com/%s", bucket, key)
+func (u *Uploader) openStdin() (string, io.ReadSeekCloser, error) {
+	file, err := u.createTemp()
+	if err != nil {
+		return "", nil, fmt.Errorf("error spooling stdin: %w", err)
+	}
+
+	p := u.newProgress("reading stdin", 0)
+	defer p.finish()
+	sum := sha256.New()
+	w := io.MultiWriter(file, sum)
+	if _, err := io.Copy(w, p.reader(u.stdin())); err != nil {
+		_ = file.Close()
+		return "", nil, fmt.Errorf("error reading stdin: %w", err)
+	}
+
+	return u.objectKey(sum.Sum(nil), u.name("-")), file, nil
+}
+
+// name returns the file name to share path under.
+func (u *Uploader) name(path string) string {
+	switch {
+	case u.Name != "":
+		return u.Name
+	case path == "-":
+		return "stdin"
+	default:
+		return filepath.Base(path)
+	}
+}
+
+// acl returns the canned ACL to upload with. Presigned links and links
+// through a CDN work without one, so objects are only made public-read by
+// default when they are linked to directly.
+func (u *Uploader) acl() s3types.ObjectCannedACL {
+	switch u.ACL {
+	case "":
+		if u.Expires == 0 && u.BaseURL == "" {
+			return s3types.ObjectCannedACLPublicRead
+		}
+		return ""
+	case "none":
+		return ""
+	default:
+		return s3types.ObjectCannedACL(u.ACL)
+	}
 }
 
 func (u *Uploader) objectExists(key string) (bool, error) {
@@ -105,13 +347,9 @@ func (u *Uploader) objectExists(key string) (bool, error) {
 		return u.ObjectExists(key)
 	}
 
-	_, err := u.headObject(&s3.HeadObjectInput{
-		Bucket: &u.Bucket,
-		Key:    &key,
-	})
+	_, err := u.headObject(u.headInput(key))
 	if err != nil {
-		var apiErr smithy.APIError
-		if errors.As(err, &apiErr) && apiErr.ErrorCode() == "NotFound" {
+		if isNotFound(err) {
 			return false, nil
 		} else {
 			return false, err
@@ -121,24 +359,23 @@ func (u *Uploader) objectExists(key string) (bool, error) {
 	return true, nil
 }
 
-func (u *Uploader) Clone() *Uploader {
-	cl := &Uploader{
-		Bucket:  u.Bucket,
-		Client:  u.Client,
-		Context: u.Context,
+func isNotFound(err error) bool {
+	return hasErrorCode(err, "NotFound", "NoSuchKey")
+}
 
-		OpenFile:  u.OpenFile,
-		Println:   u.Println,
-		PutObject: u.PutObject,
-		Stat:      u.Stat,
+func hasErrorCode(err error, codes ...string) bool {
+	var apiErr smithy.APIError
+	return errors.As(err, &apiErr) &&
+		slices.Contains(codes, apiErr.ErrorCode())
+}
 
-		ObjectExists: u.ObjectExists,
-		SetupClient:  u.SetupClient,
-		UploadFile:   u.UploadFile,
-	}
+// Clone returns a copy of u that may be changed without affecting it, such
+// as by a worker uploading files of its own.
+func (u *Uploader) Clone() *Uploader {
+	cl := *u
 	if u.Args != nil {
 		clargs := append([]string{}, *u.Args...)
 		cl.Args = &clargs
 	}
-	return cl
+	return &cl
 }
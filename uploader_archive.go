@@ -0,0 +1,159 @@
+package main
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"path/filepath"
+)
+
+var errArchiveStdin = errors.New("stdin cannot be added to an archive")
+
+const (
+	archiveZip   = "zip"
+	archiveTarGz = "tar.gz"
+)
+
+// uploadArchive streams the files and directories at paths into a single
+// archive, spooled to a temporary file, and uploads it like any other file.
+func (u *Uploader) uploadArchive(paths []string) (string, error) {
+	for _, p := range paths {
+		if p == "-" {
+			return "", errArchiveStdin
+		}
+	}
+
+	file, err := u.createTemp()
+	if err != nil {
+		return "", fmt.Errorf("error creating archive: %w", err)
+	}
+	defer func() { _ = file.Close() }()
+
+	sum := sha256.New()
+	w := io.MultiWriter(file, sum)
+	switch u.Archive {
+	case archiveZip:
+		err = u.writeZip(w, paths)
+	case archiveTarGz:
+		err = u.writeTarGz(w, paths)
+	default:
+		err = fmt.Errorf("unknown archive format: %s", u.Archive)
+	}
+	if err != nil {
+		return "", fmt.Errorf("error creating archive: %w", err)
+	}
+
+	if u.Encrypt {
+		if _, err := file.Seek(0, io.SeekStart); err != nil {
+			return "", err
+		}
+		return u.encryptUpload(u.archiveName(paths[0]), file, 0)
+	}
+	name := u.archiveName(paths[0])
+	return u.uploadKeyed(u.objectKey(sum.Sum(nil), name), name, file)
+}
+
+func (u *Uploader) archiveName(first string) string {
+	if u.Name != "" {
+		return u.Name
+	}
+	if abs, err := filepath.Abs(first); err == nil {
+		first = abs
+	}
+	return filepath.Base(first) + "." + u.Archive
+}
+
+func (u *Uploader) writeZip(w io.Writer, paths []string) error {
+	zw := zip.NewWriter(w)
+	err := u.walkArchive(paths, func(name, path string, fi fs.FileInfo) error {
+		hdr, err := zip.FileInfoHeader(fi)
+		if err != nil {
+			return err
+		}
+		hdr.Name = name
+		hdr.Method = zip.Deflate
+		dst, err := zw.CreateHeader(hdr)
+		if err != nil {
+			return err
+		}
+		return u.copyFile(dst, path)
+	})
+	if err != nil {
+		return err
+	}
+	return zw.Close()
+}
+
+func (u *Uploader) writeTarGz(w io.Writer, paths []string) error {
+	gw := gzip.NewWriter(w)
+	tw := tar.NewWriter(gw)
+	err := u.walkArchive(paths, func(name, path string, fi fs.FileInfo) error {
+		hdr, err := tar.FileInfoHeader(fi, "")
+		if err != nil {
+			return err
+		}
+		hdr.Name = name
+		if err := tw.WriteHeader(hdr); err != nil {
+			return err
+		}
+		return u.copyFile(tw, path)
+	})
+	if err != nil {
+		return err
+	}
+	if err := tw.Close(); err != nil {
+		return err
+	}
+	return gw.Close()
+}
+
+// walkArchive calls fn for every regular file under paths with the name it
+// should have in an archive: its path relative to the parent of the
+// argument it was found under.
+func (u *Uploader) walkArchive(
+	paths []string, fn func(name, path string, fi fs.FileInfo) error,
+) error {
+	for _, root := range paths {
+		parent := filepath.Dir(filepath.Clean(root))
+		walk := func(path string, d fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			} else if d.IsDir() {
+				return nil
+			}
+
+			fi, err := u.stat(path)
+			if err != nil {
+				return err
+			} else if !fi.Mode().IsRegular() {
+				return nil
+			}
+
+			name, err := filepath.Rel(parent, path)
+			if err != nil {
+				return err
+			}
+			return fn(filepath.ToSlash(name), path, fi)
+		}
+		if err := u.walkDir(root, walk); err != nil {
+			return fmt.Errorf("%s: %w", root, err)
+		}
+	}
+	return nil
+}
+
+func (u *Uploader) copyFile(w io.Writer, path string) error {
+	file, err := u.openFile(path)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = file.Close() }()
+
+	_, err = io.Copy(w, file)
+	return err
+}
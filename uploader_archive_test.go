@@ -0,0 +1,117 @@
+package main
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"io"
+	"os"
+	"strings"
+	"testing"
+	"testing/fstest"
+
+	"gotest.tools/v3/assert"
+)
+
+var testArchiveFS = fstest.MapFS{
+	"notes.txt":        {Data: []byte("notes")},
+	"logs/one.log":     {Data: []byte("one")},
+	"logs/sub/two.log": {Data: []byte("two")},
+}
+
+func newArchiveRun(t *testing.T, format string) *dirRun {
+	r := newDirRun(t, testArchiveFS)
+	dir := t.TempDir()
+	r.Uploader.Archive = format
+	r.Uploader.CreateTemp = func() (*os.File, error) {
+		return os.CreateTemp(dir, "archive")
+	}
+	return r
+}
+
+func TestUploadArchiveZip(t *testing.T) {
+	r := newArchiveRun(t, archiveZip)
+
+	url, err := r.Uploader.uploadArchive([]string{"notes.txt", "logs"})
+
+	assert.NilError(t, err)
+	assert.Equal(t, len(r.PutObjectCalls), 1)
+	key := *r.PutObjectCalls[0].Key
+	assert.Assert(t, strings.HasSuffix(key, "/notes.txt.zip"))
+	assert.Equal(t, url, "https://somebucket.s3.amazonaws.com/"+key)
+
+	body := r.Bodies[key]
+	zr, err := zip.NewReader(strings.NewReader(body), int64(len(body)))
+	assert.NilError(t, err)
+	got := map[string]string{}
+	for _, f := range zr.File {
+		rc, err := f.Open()
+		assert.NilError(t, err)
+		b, err := io.ReadAll(rc)
+		assert.NilError(t, err)
+		got[f.Name] = string(b)
+	}
+	assert.DeepEqual(t, got, map[string]string{
+		"notes.txt":        "notes",
+		"logs/one.log":     "one",
+		"logs/sub/two.log": "two",
+	})
+}
+
+func TestUploadArchiveTarGz(t *testing.T) {
+	r := newArchiveRun(t, archiveTarGz)
+	r.Uploader.Name = "bundle.tgz"
+
+	_, err := r.Uploader.uploadArchive([]string{"logs"})
+
+	assert.NilError(t, err)
+	key := *r.PutObjectCalls[0].Key
+	assert.Assert(t, strings.HasSuffix(key, "/bundle.tgz"))
+
+	gr, err := gzip.NewReader(bytes.NewReader([]byte(r.Bodies[key])))
+	assert.NilError(t, err)
+	tr := tar.NewReader(gr)
+	var names []string
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		assert.NilError(t, err)
+		names = append(names, hdr.Name)
+	}
+	assert.DeepEqual(t, names, []string{"logs/one.log", "logs/sub/two.log"})
+}
+
+func TestUploadArchiveStable(t *testing.T) {
+	r := newArchiveRun(t, archiveZip)
+
+	url1, err := r.Uploader.uploadArchive([]string{"notes.txt", "logs"})
+	assert.NilError(t, err)
+	url2, err := r.Uploader.uploadArchive([]string{"notes.txt", "logs"})
+	assert.NilError(t, err)
+
+	assert.Equal(t, url1, url2)
+}
+
+func TestUploadArchiveStdin(t *testing.T) {
+	r := newArchiveRun(t, archiveZip)
+
+	_, err := r.Uploader.uploadArchive([]string{"notes.txt", "-"})
+
+	assert.ErrorIs(t, err, errArchiveStdin)
+}
+
+func TestRunArchivePrintsOneURL(t *testing.T) {
+	r := newArchiveRun(t, "")
+	r.Uploader.Args = &[]string{
+		"s3share", "--tar.gz", "notes.txt", "logs",
+	}
+
+	err := run(r.Uploader)
+
+	assert.NilError(t, err)
+	assert.Equal(t, len(r.Stdout), 1)
+	assert.Assert(t, strings.HasSuffix(r.Stdout[0], "/notes.txt.tar.gz"))
+}
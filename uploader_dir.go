@@ -0,0 +1,244 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"html/template"
+	"io"
+	"io/fs"
+	"path/filepath"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+)
+
+var errEmptyDir = errors.New("directory has no files to upload")
+
+const indexName = "index.html"
+
+var indexTemplate = template.Must(template.New(indexName).Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<title>{{.Name}}</title>
+</head>
+<body>
+<h1>{{.Name}}</h1>
+<ul>
+{{- range .Files}}
+<li><a href="{{.URL}}">{{.Rel}}</a> ({{.Size}} bytes)</li>
+{{- end}}
+</ul>
+</body>
+</html>
+`))
+
+// dirFile is a regular file found while walking a directory.
+type dirFile struct {
+	Path string
+	Rel  string
+	Size int64
+	Sum  []byte
+	URL  string
+}
+
+// uploadDir uploads every file under dir beneath a shared prefix derived
+// from the relative paths and hashes of its contents, so an unchanged
+// directory always lands on the same keys. An index.html linking to each
+// file is generated unless the directory already has one at its top level,
+// and the index URL is returned.
+func (u *Uploader) uploadDir(dir string) (string, error) {
+	files, err := u.walkFiles(dir)
+	if err != nil {
+		return "", err
+	} else if len(files) == 0 {
+		return "", fmt.Errorf("%w: %s", errEmptyDir, dir)
+	}
+
+	var index *dirFile
+	for _, f := range files {
+		if f.Rel == indexName {
+			index = f
+		}
+	}
+	base := u.dirKey(dir, files)
+	indexKey := base + "/" + indexName
+
+	// The index is uploaded last, so finding it means a previous run
+	// finished. Generated indexes of signed links are always refreshed
+	// since the links in them expire.
+	if u.dedupes() && (index != nil || !u.signed()) {
+		if ok, err := u.objectExists(indexKey); err != nil {
+			return "", err
+		} else if ok {
+			keys := []string{indexKey}
+			for _, f := range files {
+				if f != index {
+					keys = append(keys, base+"/"+f.Rel)
+				}
+			}
+			for _, key := range keys {
+				if err := u.refreshExpiry(key); err != nil {
+					return "", err
+				}
+			}
+			return u.objectUrl(indexKey)
+		}
+	}
+
+	for _, f := range files {
+		if f == index {
+			continue
+		}
+		key := base + "/" + f.Rel
+		if err := u.uploadDirFile(key, f.Path); err != nil {
+			return "", err
+		}
+		if f.URL, err = u.indexLink(key, f.Rel); err != nil {
+			return "", err
+		}
+	}
+
+	if index != nil {
+		if err := u.uploadDirFile(indexKey, index.Path); err != nil {
+			return "", err
+		}
+		return u.objectUrl(indexKey)
+	}
+
+	var buf bytes.Buffer
+	err = indexTemplate.Execute(&buf, struct {
+		Name  string
+		Files []*dirFile
+	}{u.dirName(dir), files})
+	if err != nil {
+		return "", fmt.Errorf("error generating index: %w", err)
+	}
+	in := u.putInput(indexKey, bytes.NewReader(buf.Bytes()))
+	if err := u.contentHeaders(in, indexName); err != nil {
+		return "", err
+	}
+	in.ContentType = aws.String("text/html; charset=utf-8")
+	in.ContentDisposition = nil
+	if u.signed() {
+		// The links in it expire, so it is replaced on every share.
+		in.CacheControl = aws.String("no-cache")
+	}
+	if err := u.upload(in); err != nil {
+		return "", err
+	}
+
+	return u.objectUrl(indexKey)
+}
+
+// dirKey returns the key every file of dir is uploaded beneath, derived
+// from the relative paths and hashes of files.
+func (u *Uploader) dirKey(dir string, files []*dirFile) string {
+	manifest := sha256.New()
+	for _, f := range files {
+		_, _ = fmt.Fprintf(manifest, "%s\x00%x\n", f.Rel, f.Sum)
+	}
+	return u.objectKey(manifest.Sum(nil), u.dirName(dir))
+}
+
+// walkFiles lists the regular files under dir in lexical order, following
+// symbolic links to files, and hashes each of them.
+func (u *Uploader) walkFiles(dir string) ([]*dirFile, error) {
+	var files []*dirFile
+	err := u.walkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		} else if d.IsDir() {
+			return nil
+		}
+
+		fi, err := u.stat(path)
+		if err != nil {
+			return err
+		} else if !fi.Mode().IsRegular() {
+			return nil
+		}
+
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+		sum, err := u.hashFile(path, fi.Size())
+		if err != nil {
+			return err
+		}
+
+		files = append(files, &dirFile{
+			Path: path,
+			Rel:  filepath.ToSlash(rel),
+			Size: fi.Size(),
+			Sum:  sum,
+		})
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error reading directory %s: %w", dir, err)
+	}
+	return files, nil
+}
+
+func (u *Uploader) hashFile(path string, size int64) ([]byte, error) {
+	file, err := u.openFile(path)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = file.Close() }()
+
+	p := u.newProgress("hashing "+filepath.Base(path), size)
+	defer p.finish()
+	sum := sha256.New()
+	if _, err := io.Copy(sum, p.reader(file)); err != nil {
+		return nil, fmt.Errorf("error computing file hash: %w", err)
+	}
+	return sum.Sum(nil), nil
+}
+
+func (u *Uploader) uploadDirFile(key, path string) error {
+	if u.dedupes() {
+		if ok, err := u.objectExists(key); err != nil {
+			return err
+		} else if ok {
+			return u.refreshExpiry(key)
+		}
+	}
+
+	file, err := u.openFile(path)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = file.Close() }()
+
+	in := u.putInput(key, file)
+	if err := u.contentHeaders(in, filepath.Base(path)); err != nil {
+		return err
+	}
+	return u.upload(in)
+}
+
+// indexLink returns the link to key from the index. Links are relative so
+// the index works behind any host, except signed links which each carry
+// their own signature.
+func (u *Uploader) indexLink(key, rel string) (string, error) {
+	if u.signed() {
+		return u.objectUrl(key)
+	}
+
+	return escapeKey(rel), nil
+}
+
+func (u *Uploader) dirName(dir string) string {
+	if u.Name != "" {
+		return u.Name
+	}
+	if abs, err := filepath.Abs(dir); err == nil {
+		dir = abs
+	}
+	return filepath.Base(dir)
+}
@@ -0,0 +1,215 @@
+package main
+
+import (
+	"io"
+	"io/fs"
+	"strings"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	s3manager "github.com/aws/aws-sdk-go-v2/feature/s3/manager"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	s3types "github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"gotest.tools/v3/assert"
+)
+
+type dirRun struct {
+	*testRun
+	Bodies map[string]string
+}
+
+func newDirRun(t *testing.T, fsys fstest.MapFS) *dirRun {
+	r := &dirRun{testRun: newTestRun(t), Bodies: map[string]string{}}
+	u := r.Uploader
+	u.UploadFile = nil
+	u.Stat = func(name string) (fs.FileInfo, error) {
+		return fs.Stat(fsys, name)
+	}
+	u.WalkDir = func(root string, fn fs.WalkDirFunc) error {
+		return fs.WalkDir(fsys, root, fn)
+	}
+	u.OpenFile = func(name string) (io.ReadSeekCloser, error) {
+		f, err := fsys.Open(name)
+		if err != nil {
+			return nil, err
+		}
+		return f.(io.ReadSeekCloser), nil
+	}
+	u.PutObject = func(
+		in *s3.PutObjectInput,
+	) (*s3manager.UploadOutput, error) {
+		r.PutObjectCalls = append(r.PutObjectCalls, in)
+		b, err := io.ReadAll(in.Body)
+		r.Bodies[*in.Key] = string(b)
+		return &s3manager.UploadOutput{}, err
+	}
+	return r
+}
+
+func (r *dirRun) Keys() []string {
+	var keys []string
+	for _, in := range r.PutObjectCalls {
+		keys = append(keys, *in.Key)
+	}
+	return keys
+}
+
+var testDir = fstest.MapFS{
+	"report/a.txt":            {Data: []byte("a")},
+	"report/sub/b c.txt":      {Data: []byte("b")},
+	"report/sub/empty/.keep":  {Data: []byte{}},
+	"report/sub/nested/c.txt": {Data: []byte("c")},
+}
+
+func TestUploadDir(t *testing.T) {
+	r := newDirRun(t, testDir)
+
+	url, err := r.Uploader.uploadFile("report")
+
+	assert.NilError(t, err)
+	keys := r.Keys()
+	assert.Equal(t, len(keys), 5)
+	base := strings.TrimSuffix(keys[4], "/index.html")
+	assert.Assert(t, base != keys[4])
+	assert.Assert(t, strings.HasSuffix(base, "/report"))
+	assert.DeepEqual(t, keys, []string{
+		base + "/a.txt",
+		base + "/sub/b c.txt",
+		base + "/sub/empty/.keep",
+		base + "/sub/nested/c.txt",
+		base + "/index.html",
+	})
+	assert.Equal(t, url, "https://somebucket.s3.amazonaws.com/"+
+		base+"/index.html")
+	assert.Equal(t, *r.PutObjectCalls[4].ContentType,
+		"text/html; charset=utf-8")
+	index := r.Bodies[base+"/index.html"]
+	assert.Assert(t, strings.Contains(index, `href="a.txt"`))
+	assert.Assert(t, strings.Contains(index, `href="sub/b%20c.txt"`))
+	assert.Assert(t, strings.Contains(index, `href="sub/nested/c.txt"`))
+}
+
+func TestUploadDirStableKeys(t *testing.T) {
+	fsys := fstest.MapFS{}
+	for k, v := range testDir {
+		fsys[k] = v
+	}
+	r := newDirRun(t, fsys)
+
+	url1, err := r.Uploader.uploadFile("report")
+	assert.NilError(t, err)
+	url2, err := r.Uploader.uploadFile("report")
+	assert.NilError(t, err)
+	fsys["report/a.txt"] = &fstest.MapFile{Data: []byte("changed")}
+	url3, err := r.Uploader.uploadFile("report")
+	assert.NilError(t, err)
+
+	assert.Equal(t, url1, url2)
+	assert.Assert(t, url1 != url3)
+}
+
+func TestUploadDirIndexExists(t *testing.T) {
+	r := newDirRun(t, testDir)
+	r.Uploader.ObjectExists = func(key string) (bool, error) {
+		r.ObjectExistsCalls = append(r.ObjectExistsCalls, key)
+		return strings.HasSuffix(key, "/index.html"), nil
+	}
+
+	url, err := r.Uploader.uploadFile("report")
+
+	assert.NilError(t, err)
+	assert.Assert(t, strings.HasSuffix(url, "/report/index.html"))
+	assert.Equal(t, len(r.PutObjectCalls), 0)
+	assert.Equal(t, len(r.ObjectExistsCalls), 1)
+}
+
+func TestUploadDirOwnIndex(t *testing.T) {
+	fsys := fstest.MapFS{
+		"site/index.html": {Data: []byte("<p>mine</p>")},
+		"site/style.css":  {Data: []byte("p{}")},
+	}
+	r := newDirRun(t, fsys)
+
+	url, err := r.Uploader.uploadFile("site")
+
+	assert.NilError(t, err)
+	keys := r.Keys()
+	assert.Equal(t, len(keys), 2)
+	assert.Assert(t, strings.HasSuffix(keys[0], "/site/style.css"))
+	assert.Assert(t, strings.HasSuffix(keys[1], "/site/index.html"))
+	assert.Equal(t, r.Bodies[keys[1]], "<p>mine</p>")
+	assert.Assert(t, strings.HasSuffix(url, "/site/index.html"))
+}
+
+func TestUploadDirEmpty(t *testing.T) {
+	fsys := fstest.MapFS{"empty/sub": {Mode: fs.ModeDir}}
+	r := newDirRun(t, fsys)
+
+	_, err := r.Uploader.uploadFile("empty")
+
+	assert.ErrorIs(t, err, errEmptyDir)
+}
+
+func TestRmDir(t *testing.T) {
+	r := newDirRun(t, testDir)
+	r.Uploader.Yes = true
+	r.Uploader.Client = new(s3Client)
+	r.Uploader.Client._DeleteObject_Return(&s3.DeleteObjectOutput{}, nil)
+	_, err := r.Uploader.uploadFile("report")
+	assert.NilError(t, err)
+	var objs []s3types.Object
+	for _, key := range append(r.Keys(), "other/key.txt") {
+		objs = append(objs, s3types.Object{Key: aws.String(key)})
+	}
+	r.Uploader.Client._ListObjectsV2_Return(
+		&s3.ListObjectsV2Output{Contents: objs}, nil,
+	)
+
+	err = r.Uploader.rm([]string{"report"})
+
+	assert.NilError(t, err)
+	var deleted []string
+	for _, call := range r.Uploader.Client._DeleteObject_Calls() {
+		deleted = append(deleted, *call.Params.Key)
+	}
+	assert.DeepEqual(t, deleted, r.Keys())
+}
+
+func TestUploadDirDryRun(t *testing.T) {
+	r := newDirRun(t, testDir)
+	r.Uploader.DryRun = true
+
+	_, err := r.Uploader.uploadFile("report")
+
+	assert.NilError(t, err)
+	assert.Equal(t, len(r.PutObjectCalls), 0)
+	assert.Equal(t, len(r.Stderr), 5)
+	assert.Assert(t, strings.HasSuffix(r.Stderr[4], "/report/index.html"))
+}
+
+func TestUploadDirCloudFrontSigned(t *testing.T) {
+	r := newDirRun(t, testDir)
+	r.Uploader.BaseURL = "https://files.example.com"
+	r.Uploader.Expires = time.Hour
+	r.Uploader.CFKeyPairID = "K2JCJMDEHXQW5F"
+	r.Uploader.CFKey = testCloudFrontKey()
+	r.Uploader.Now = func() time.Time { return testNow }
+	r.Uploader.ObjectExists = func(key string) (bool, error) {
+		return strings.HasSuffix(key, "/index.html"), nil
+	}
+
+	url, err := r.Uploader.uploadFile("report")
+
+	assert.NilError(t, err)
+	assert.Assert(t, strings.Contains(url, "/report/index.html?Expires="))
+	keys := r.Keys()
+	assert.Equal(t, len(keys), 5)
+	index := r.PutObjectCalls[4]
+	assert.Equal(t, *index.CacheControl, "no-cache")
+	body := r.Bodies[keys[4]]
+	assert.Assert(t, strings.Contains(body, `href="https://files.example.com/`))
+	assert.Assert(t, strings.Contains(body, `/report/a.txt?Expires=`))
+	assert.Assert(t, !strings.Contains(body, `href="a.txt"`))
+}
@@ -3,8 +3,15 @@ package main
 import (
 	"fmt"
 	"io"
+	"io/fs"
+	"net/http"
 	"os"
+	"os/user"
+	"path/filepath"
+	"time"
 
+	"github.com/aws/aws-sdk-go-v2/aws"
+	v4 "github.com/aws/aws-sdk-go-v2/aws/signer/v4"
 	awscfg "github.com/aws/aws-sdk-go-v2/config"
 	s3manager "github.com/aws/aws-sdk-go-v2/feature/s3/manager"
 	"github.com/aws/aws-sdk-go-v2/service/s3"
@@ -33,6 +40,22 @@ func (u *Uploader) args() []string {
 	return os.Args
 }
 
+func (u *Uploader) stdin() io.Reader {
+	if u.Stdin != nil {
+		return u.Stdin
+	}
+
+	return os.Stdin
+}
+
+func (u *Uploader) stdout() io.Writer {
+	if u.Stdout != nil {
+		return u.Stdout
+	}
+
+	return os.Stdout
+}
+
 func (u *Uploader) println(args ...any) (int, error) {
 	if u.Println != nil {
 		return u.Println(args...)
@@ -41,6 +64,103 @@ func (u *Uploader) println(args ...any) (int, error) {
 	return fmt.Println(args...)
 }
 
+func (u *Uploader) eprint(args ...any) (int, error) {
+	if u.Eprint != nil {
+		return u.Eprint(args...)
+	}
+
+	return fmt.Fprint(os.Stderr, args...)
+}
+
+// isTerminal reports whether standard error is a terminal, where progress
+// may be drawn.
+func (u *Uploader) isTerminal() bool {
+	if u.IsTerminal != nil {
+		return u.IsTerminal()
+	}
+
+	fi, err := os.Stderr.Stat()
+	return err == nil && fi.Mode()&os.ModeCharDevice != 0
+}
+
+func (u *Uploader) eprintln(args ...any) (int, error) {
+	if u.Eprintln != nil {
+		return u.Eprintln(args...)
+	}
+
+	return fmt.Fprintln(os.Stderr, args...)
+}
+
+func (u *Uploader) now() time.Time {
+	if u.Now != nil {
+		return u.Now()
+	}
+
+	return time.Now()
+}
+
+func (u *Uploader) readFile(name string) ([]byte, error) {
+	if u.ReadFile != nil {
+		return u.ReadFile(name)
+	}
+
+	return os.ReadFile(name)
+}
+
+// saveFile replaces the file name with b, creating its directory if
+// needed. The file is written next to it first, so it is never left half
+// written.
+func (u *Uploader) saveFile(name string, b []byte) error {
+	if u.SaveFile != nil {
+		return u.SaveFile(name, b)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(name), 0o700); err != nil {
+		return err
+	}
+	tmp := name + ".tmp"
+	if err := os.WriteFile(tmp, b, 0o600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, name)
+}
+
+func (u *Uploader) removeFile(name string) error {
+	if u.RemoveFile != nil {
+		return u.RemoveFile(name)
+	}
+
+	return os.Remove(name)
+}
+
+func (u *Uploader) cacheDir() (string, error) {
+	if u.CacheDir != nil {
+		return u.CacheDir()
+	}
+
+	return os.UserCacheDir()
+}
+
+func (u *Uploader) username() (string, error) {
+	if u.Username != nil {
+		return u.Username()
+	}
+
+	cur, err := user.Current()
+	if err != nil {
+		return "", err
+	}
+	return cur.Username, nil
+}
+
+func (u *Uploader) hostname() (string, error) {
+	if u.Hostname != nil {
+		return u.Hostname()
+	}
+
+	return os.Hostname()
+}
+
 func (u *Uploader) stat(name string) (os.FileInfo, error) {
 	if u.Stat != nil {
 		return u.Stat(name)
@@ -49,19 +169,91 @@ func (u *Uploader) stat(name string) (os.FileInfo, error) {
 	return os.Stat(name)
 }
 
+func (u *Uploader) walkDir(root string, fn fs.WalkDirFunc) error {
+	if u.WalkDir != nil {
+		return u.WalkDir(root, fn)
+	}
+
+	return filepath.WalkDir(root, fn)
+}
+
 func (u *Uploader) setupClient() error {
 	if u.SetupClient != nil {
 		return u.SetupClient()
 	}
 
-	cfg, err := awscfg.LoadDefaultConfig(u.Context)
+	var opts []func(*awscfg.LoadOptions) error
+	if u.Region != "" {
+		opts = append(opts, awscfg.WithRegion(u.Region))
+	}
+	if u.Profile != "" {
+		opts = append(opts, awscfg.WithSharedConfigProfile(u.Profile))
+	}
+	cfg, err := awscfg.LoadDefaultConfig(u.Context, opts...)
 	if err != nil {
 		return err
 	}
-	u.Client.Client = s3.NewFromConfig(cfg)
+	if cfg.Region == "" {
+		// The client refuses to sign requests without a region, even for
+		// S3-compatible stores that ignore it.
+		cfg.Region = defaultRegion
+	}
+
+	u.Client = u.newClient(cfg)
+	if u.Region == "" && u.Endpoint == "" {
+		// Requests to a bucket through another region's endpoint fail, so
+		// the client is rebuilt once the bucket's region is known.
+		if r, err := u.bucketRegion(); err == nil && r != cfg.Region {
+			cfg.Region = r
+			u.Client = u.newClient(cfg)
+		}
+	}
+	u.Region = cfg.Region
 	return nil
 }
 
+func (u *Uploader) newClient(cfg aws.Config) *s3Client {
+	return &s3Client{Client: s3.NewFromConfig(cfg, func(o *s3.Options) {
+		if u.Endpoint != "" {
+			o.BaseEndpoint = &u.Endpoint
+		}
+		o.UsePathStyle = u.PathStyle
+		if u.DualStack {
+			o.EndpointOptions.UseDualStackEndpoint =
+				aws.DualStackEndpointStateEnabled
+		}
+		if u.FIPS {
+			o.EndpointOptions.UseFIPSEndpoint = aws.FIPSEndpointStateEnabled
+		}
+	})}
+}
+
+// bucketRegion asks S3 which region the bucket is in, trying HeadBucket if
+// the caller is not allowed to call GetBucketLocation. S3 names the region
+// in a header of its answer to HeadBucket, even when that answer is a
+// redirect to the bucket's own endpoint.
+func (u *Uploader) bucketRegion() (string, error) {
+	loc, err := u.Client.GetBucketLocation(
+		u.Context, &s3.GetBucketLocationInput{Bucket: &u.Bucket},
+	)
+	if err == nil {
+		switch r := string(loc.LocationConstraint); r {
+		case "":
+			return defaultRegion, nil
+		case "EU":
+			return "eu-west-1", nil
+		default:
+			return r, nil
+		}
+	}
+
+	r, headErr := s3manager.GetBucketRegion(u.Context, u.Client, u.Bucket)
+	if headErr == nil && r != "" {
+		return r, nil
+	}
+	return "", fmt.Errorf("error finding bucket region: %w", err)
+}
+
 func (u *Uploader) headObject(
 	in *s3.HeadObjectInput,
 ) (*s3.HeadObjectOutput, error) {
@@ -73,6 +265,261 @@ func (u *Uploader) headObject(
 	return u.Client.HeadObject(u.Context, in)
 }
 
+func (u *Uploader) getObject(
+	in *s3.GetObjectInput,
+) (*s3.GetObjectOutput, error) {
+	if u.Client == nil {
+		if err := u.setupClient(); err != nil {
+			return nil, err
+		}
+	}
+	return u.Client.GetObject(u.Context, in)
+}
+
+func (u *Uploader) listObjectsV2(
+	in *s3.ListObjectsV2Input,
+) (*s3.ListObjectsV2Output, error) {
+	if u.Client == nil {
+		if err := u.setupClient(); err != nil {
+			return nil, err
+		}
+	}
+	return u.Client.ListObjectsV2(u.Context, in)
+}
+
+func (u *Uploader) deleteObject(
+	in *s3.DeleteObjectInput,
+) (*s3.DeleteObjectOutput, error) {
+	if u.Client == nil {
+		if err := u.setupClient(); err != nil {
+			return nil, err
+		}
+	}
+	return u.Client.DeleteObject(u.Context, in)
+}
+
+func (u *Uploader) deleteObjects(
+	in *s3.DeleteObjectsInput,
+) (*s3.DeleteObjectsOutput, error) {
+	if u.Client == nil {
+		if err := u.setupClient(); err != nil {
+			return nil, err
+		}
+	}
+	return u.Client.DeleteObjects(u.Context, in)
+}
+
+func (u *Uploader) copyObject(
+	in *s3.CopyObjectInput,
+) (*s3.CopyObjectOutput, error) {
+	if u.Client == nil {
+		if err := u.setupClient(); err != nil {
+			return nil, err
+		}
+	}
+	return u.Client.CopyObject(u.Context, in)
+}
+
+func (u *Uploader) listMultipartUploads(
+	in *s3.ListMultipartUploadsInput,
+) (*s3.ListMultipartUploadsOutput, error) {
+	if u.Client == nil {
+		if err := u.setupClient(); err != nil {
+			return nil, err
+		}
+	}
+	return u.Client.ListMultipartUploads(u.Context, in)
+}
+
+func (u *Uploader) createMultipartUpload(
+	in *s3.CreateMultipartUploadInput,
+) (*s3.CreateMultipartUploadOutput, error) {
+	if u.Client == nil {
+		if err := u.setupClient(); err != nil {
+			return nil, err
+		}
+	}
+	return u.Client.CreateMultipartUpload(u.Context, in)
+}
+
+func (u *Uploader) uploadPartCopy(
+	in *s3.UploadPartCopyInput,
+) (*s3.UploadPartCopyOutput, error) {
+	if u.Client == nil {
+		if err := u.setupClient(); err != nil {
+			return nil, err
+		}
+	}
+	return u.Client.UploadPartCopy(u.Context, in)
+}
+
+func (u *Uploader) uploadPart(
+	in *s3.UploadPartInput,
+) (*s3.UploadPartOutput, error) {
+	if u.Client == nil {
+		if err := u.setupClient(); err != nil {
+			return nil, err
+		}
+	}
+	return u.Client.UploadPart(u.Context, in)
+}
+
+func (u *Uploader) listParts(
+	in *s3.ListPartsInput,
+) (*s3.ListPartsOutput, error) {
+	if u.Client == nil {
+		if err := u.setupClient(); err != nil {
+			return nil, err
+		}
+	}
+	return u.Client.ListParts(u.Context, in)
+}
+
+func (u *Uploader) completeMultipartUpload(
+	in *s3.CompleteMultipartUploadInput,
+) (*s3.CompleteMultipartUploadOutput, error) {
+	if u.Client == nil {
+		if err := u.setupClient(); err != nil {
+			return nil, err
+		}
+	}
+	return u.Client.CompleteMultipartUpload(u.Context, in)
+}
+
+func (u *Uploader) abortMultipartUpload(
+	in *s3.AbortMultipartUploadInput,
+) (*s3.AbortMultipartUploadOutput, error) {
+	if u.Client == nil {
+		if err := u.setupClient(); err != nil {
+			return nil, err
+		}
+	}
+	return u.Client.AbortMultipartUpload(u.Context, in)
+}
+
+func (u *Uploader) getBucketLifecycleConfiguration(
+	in *s3.GetBucketLifecycleConfigurationInput,
+) (*s3.GetBucketLifecycleConfigurationOutput, error) {
+	if u.Client == nil {
+		if err := u.setupClient(); err != nil {
+			return nil, err
+		}
+	}
+	return u.Client.GetBucketLifecycleConfiguration(u.Context, in)
+}
+
+func (u *Uploader) putBucketLifecycleConfiguration(
+	in *s3.PutBucketLifecycleConfigurationInput,
+) (*s3.PutBucketLifecycleConfigurationOutput, error) {
+	if u.Client == nil {
+		if err := u.setupClient(); err != nil {
+			return nil, err
+		}
+	}
+	return u.Client.PutBucketLifecycleConfiguration(u.Context, in)
+}
+
+func (u *Uploader) deleteBucketLifecycle(
+	in *s3.DeleteBucketLifecycleInput,
+) (*s3.DeleteBucketLifecycleOutput, error) {
+	if u.Client == nil {
+		if err := u.setupClient(); err != nil {
+			return nil, err
+		}
+	}
+	return u.Client.DeleteBucketLifecycle(u.Context, in)
+}
+
+func (u *Uploader) getBucketCors(
+	in *s3.GetBucketCorsInput,
+) (*s3.GetBucketCorsOutput, error) {
+	if u.Client == nil {
+		if err := u.setupClient(); err != nil {
+			return nil, err
+		}
+	}
+	return u.Client.GetBucketCors(u.Context, in)
+}
+
+func (u *Uploader) putBucketCors(
+	in *s3.PutBucketCorsInput,
+) (*s3.PutBucketCorsOutput, error) {
+	if u.Client == nil {
+		if err := u.setupClient(); err != nil {
+			return nil, err
+		}
+	}
+	return u.Client.PutBucketCors(u.Context, in)
+}
+
+func (u *Uploader) deleteBucketCors(
+	in *s3.DeleteBucketCorsInput,
+) (*s3.DeleteBucketCorsOutput, error) {
+	if u.Client == nil {
+		if err := u.setupClient(); err != nil {
+			return nil, err
+		}
+	}
+	return u.Client.DeleteBucketCors(u.Context, in)
+}
+
+// writeFile saves r to the new file name, leaving nothing behind if
+// reading r fails.
+func (u *Uploader) writeFile(name string, r io.Reader) error {
+	if u.WriteFile != nil {
+		return u.WriteFile(name, r)
+	}
+
+	if _, err := os.Lstat(name); err == nil {
+		return fs.ErrExist
+	}
+	f, err := os.CreateTemp(filepath.Dir(name), ".s3share-*")
+	if err != nil {
+		return err
+	}
+	defer func() { _ = os.Remove(f.Name()) }()
+	if _, err := io.Copy(f, r); err != nil {
+		_ = f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), name)
+}
+
+// fetch GETs link, sending the SSE-C key if there is one. Compressed
+// bodies are returned as they are.
+func (u *Uploader) fetch(link string) (*http.Response, error) {
+	if u.Fetch != nil {
+		return u.Fetch(link)
+	}
+
+	req, err := http.NewRequestWithContext(u.Context, "GET", link, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept-Encoding", "identity")
+	if alg, key, keyMD5 := u.ssec(); key != nil {
+		req.Header.Set(
+			"x-amz-server-side-encryption-customer-algorithm", *alg,
+		)
+		req.Header.Set("x-amz-server-side-encryption-customer-key", *key)
+		req.Header.Set(
+			"x-amz-server-side-encryption-customer-key-MD5", *keyMD5,
+		)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		_ = resp.Body.Close()
+		return nil, fmt.Errorf("error fetching %s: %s", link, resp.Status)
+	}
+	return resp, nil
+}
+
 func (u *Uploader) openFile(path string) (io.ReadSeekCloser, error) {
 	if u.OpenFile != nil {
 		return u.OpenFile(path)
@@ -94,5 +541,59 @@ func (u *Uploader) putObject(
 		}
 	}
 
-	return s3manager.NewUploader(u.Client).Upload(u.Context, in)
+	size := bodySize(in)
+	m := s3manager.NewUploader(u.Client, u.uploadOptions(size))
+	if size > m.PartSize*int64(m.MaxUploadParts) {
+		return nil, fmt.Errorf("%w: %s is %s",
+			errTooManyParts, *in.Key, formatSize(size))
+	}
+	if body, ok := in.Body.(io.ReadSeeker); ok && u.Resume &&
+		size > m.PartSize {
+		return u.putResumable(in, body, size)
+	}
+	return m.Upload(u.Context, in)
+}
+
+func (u *Uploader) presignGetObject(
+	in *s3.GetObjectInput,
+) (*v4.PresignedHTTPRequest, error) {
+	if u.PresignGetObject != nil {
+		return u.PresignGetObject(in)
+	}
+
+	if u.Client == nil {
+		if err := u.setupClient(); err != nil {
+			return nil, err
+		}
+	}
+
+	return s3.NewPresignClient(u.Client.Client).PresignGetObject(
+		u.Context, in, s3.WithPresignExpires(u.Expires),
+	)
+}
+
+// tempFile is a temporary file that is removed when closed.
+type tempFile struct {
+	*os.File
+}
+
+func (f tempFile) Close() error {
+	defer func() { _ = os.Remove(f.Name()) }()
+	return f.File.Close()
+}
+
+func (u *Uploader) createTemp() (tempFile, error) {
+	var (
+		f   *os.File
+		err error
+	)
+	if u.CreateTemp != nil {
+		f, err = u.CreateTemp()
+	} else {
+		f, err = os.CreateTemp("", "s3share-*")
+	}
+	if err != nil {
+		return tempFile{}, err
+	}
+	return tempFile{f}, nil
 }
@@ -3,8 +3,10 @@ package main
 import (
 	"bytes"
 	"context"
+	"fmt"
 	"io"
 	"io/fs"
+	"strings"
 	"testing"
 
 	s3manager "github.com/aws/aws-sdk-go-v2/feature/s3/manager"
@@ -18,6 +20,15 @@ type nopCloser struct {
 
 func (c nopCloser) Close() error { c.CloseHook(); return nil }
 
+// mockFileInfo describes a regular file.
+type mockFileInfo struct {
+	fs.FileInfo
+}
+
+func (mockFileInfo) IsDir() bool       { return false }
+func (mockFileInfo) Mode() fs.FileMode { return 0o644 }
+func (mockFileInfo) Size() int64       { return int64(len(mockFileData)) }
+
 var (
 	mockFileData        = []byte("filedata")
 	mockFileDataEncoded = "M_PXf7Ma7qaZMzw6v2PyyFjL4omBIN0xN2lHGWjh7Ag"
@@ -31,9 +42,13 @@ type testRun struct {
 
 	PutObjectCalls []*s3.PutObjectInput
 
-	ObjectExistsCalls []string
-	SetupClientCalls  int
-	UploadFileCalls   []string
+	Stdout []string
+	Stderr []string
+
+	ObjectExistsCalls  []string
+	RefreshExpiryCalls []string
+	SetupClientCalls   int
+	UploadFileCalls    []string
 }
 
 var testUploader = &Uploader{
@@ -42,7 +57,7 @@ var testUploader = &Uploader{
 	Context: context.Background(),
 
 	Stat: func(string) (fs.FileInfo, error) {
-		return nil, nil
+		return mockFileInfo{}, nil
 	},
 }
 
@@ -65,6 +80,16 @@ func newTestRun(t *testing.T) *testRun {
 		}
 		return ""
 	}
+	u.Println = func(args ...any) (int, error) {
+		s := fmt.Sprintln(args...)
+		run.Stdout = append(run.Stdout, strings.TrimSuffix(s, "\n"))
+		return len(s), nil
+	}
+	u.Eprintln = func(args ...any) (int, error) {
+		s := fmt.Sprintln(args...)
+		run.Stderr = append(run.Stderr, strings.TrimSuffix(s, "\n"))
+		return len(s), nil
+	}
 	u.OpenFile = func(string) (io.ReadSeekCloser, error) {
 		return run.MockFile, nil
 	}
@@ -79,6 +104,10 @@ func newTestRun(t *testing.T) *testRun {
 		run.ObjectExistsCalls = append(run.ObjectExistsCalls, key)
 		return false, nil
 	}
+	u.RefreshExpiry = func(key string) error {
+		run.RefreshExpiryCalls = append(run.RefreshExpiryCalls, key)
+		return nil
+	}
 	u.SetupClient = func() error {
 		run.SetupClientCalls++
 		return nil
@@ -0,0 +1,220 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	s3types "github.com/aws/aws-sdk-go-v2/service/s3/types"
+)
+
+// tempPrefix is where single-pass uploads land until their hash is known.
+const tempPrefix = ".s3share-tmp/"
+
+// maxCopySize is the largest object CopyObject copies in one request.
+// Larger objects are copied in parts.
+const maxCopySize = 5 << 30
+
+// minCopyPartSize is the part size for copying large objects, raised as
+// needed to stay within 10,000 parts.
+const minCopyPartSize = 512 << 20
+
+// uploadStream uploads path to a temporary key while hashing it, reading
+// it only once, then copies it to the key its hash gives and deletes the
+// temporary object. If the key already exists, it is reused instead.
+// Standard input is read as it arrives rather than spooled first.
+func (u *Uploader) uploadStream(path string) (url string, err error) {
+	var r io.Reader
+	var size int64
+	if path == "-" {
+		r = u.stdin()
+	} else {
+		fi, err := u.stat(path)
+		if err != nil {
+			return "", fmt.Errorf(
+				"file does not exist or cannot be read: %s",
+				path,
+			)
+		}
+		file, err := u.openFile(path)
+		if err != nil {
+			return "", err
+		}
+		defer func() { _ = file.Close() }()
+		r, size = file, fi.Size()
+	}
+
+	tmp, err := u.tempKey()
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.New()
+	var n byteCounter
+	p := u.newProgress("uploading "+u.name(path), size)
+	in := u.putInput(tmp, p.reader(io.TeeReader(r, io.MultiWriter(sum, &n))))
+	in.ACL = ""
+	if err := u.contentHeaders(in, u.name(path)); err != nil {
+		return "", err
+	}
+	if size > 0 {
+		// Lets putObject pick a part size large enough for the file.
+		in.ContentLength = &size
+	}
+	stop := u.compress(in)
+	_, err = u.putObject(in)
+	stop()
+	p.finish()
+	if err != nil {
+		return "", err
+	}
+	defer func() {
+		_, derr := u.deleteObject(&s3.DeleteObjectInput{
+			Bucket: &u.Bucket,
+			Key:    &tmp,
+		})
+		if derr != nil {
+			err = errors.Join(err, fmt.Errorf(
+				"error deleting temporary object %s: %w", tmp, derr,
+			))
+		}
+	}()
+
+	key := u.objectKey(sum.Sum(nil), u.name(path))
+	var exists bool
+	if u.dedupes() {
+		if exists, err = u.objectExists(key); err != nil {
+			return "", err
+		}
+	}
+	if exists {
+		if err := u.refreshExpiry(key); err != nil {
+			return "", err
+		}
+		return u.objectUrl(key)
+	}
+
+	// Compressed uploads are stored smaller than they were read, and
+	// copying them in parts needs the size that was stored.
+	stored := int64(n)
+	if u.Compress != "" {
+		head, err := u.headObject(u.headInput(tmp))
+		if err != nil {
+			return "", err
+		}
+		stored = aws.ToInt64(head.ContentLength)
+	}
+	if err := u.copyTo(tmp, key, stored); err != nil {
+		return "", err
+	}
+	return u.objectUrl(key)
+}
+
+func (u *Uploader) tempKey() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return u.keyPrefix() + tempPrefix + hex.EncodeToString(b), nil
+}
+
+// copyTo copies the object at src of the given size to dst, keeping its
+// metadata and setting the ACL.
+func (u *Uploader) copyTo(src, dst string, size int64) error {
+	if size <= maxCopySize {
+		_, err := u.copyObject(u.copyInput(src, dst))
+		if err != nil {
+			return fmt.Errorf("error copying to %s: %w", dst, err)
+		}
+		return nil
+	}
+
+	head, err := u.headObject(u.headInput(src))
+	if err != nil {
+		return fmt.Errorf("error copying to %s: %w", dst, err)
+	}
+	create := &s3.CreateMultipartUploadInput{
+		Bucket:             &u.Bucket,
+		Key:                &dst,
+		ACL:                u.acl(),
+		Metadata:           head.Metadata,
+		CacheControl:       head.CacheControl,
+		ContentDisposition: head.ContentDisposition,
+		ContentEncoding:    head.ContentEncoding,
+		ContentType:        head.ContentType,
+	}
+	create.ServerSideEncryption, create.SSEKMSKeyId = u.sse()
+	create.SSECustomerAlgorithm, create.SSECustomerKey,
+		create.SSECustomerKeyMD5 = u.ssec()
+	if err := u.copyParts(src, create, size); err != nil {
+		return fmt.Errorf("error copying to %s: %w", dst, err)
+	}
+	return nil
+}
+
+// copyParts copies the object at src, of the given size and too large for
+// CopyObject, with the multipart upload create starts, aborting it on
+// failure.
+func (u *Uploader) copyParts(
+	src string, create *s3.CreateMultipartUploadInput, size int64,
+) error {
+	dst := *create.Key
+	mpu, err := u.createMultipartUpload(create)
+	if err != nil {
+		return err
+	}
+
+	source := escapeKey(u.Bucket + "/" + src)
+	partSize := max(minCopyPartSize, (size+9999)/10000)
+	var parts []s3types.CompletedPart
+	for off := int64(0); off < size; off += partSize {
+		num := aws.Int32(int32(len(parts) + 1))
+		in := &s3.UploadPartCopyInput{
+			Bucket:     &u.Bucket,
+			Key:        &dst,
+			UploadId:   mpu.UploadId,
+			PartNumber: num,
+			CopySource: &source,
+			CopySourceRange: aws.String(fmt.Sprintf(
+				"bytes=%d-%d", off, min(off+partSize, size)-1,
+			)),
+		}
+		in.SSECustomerAlgorithm, in.SSECustomerKey,
+			in.SSECustomerKeyMD5 = u.ssec()
+		in.CopySourceSSECustomerAlgorithm, in.CopySourceSSECustomerKey,
+			in.CopySourceSSECustomerKeyMD5 = u.ssec()
+		out, err := u.uploadPartCopy(in)
+		if err != nil {
+			_, _ = u.abortMultipartUpload(&s3.AbortMultipartUploadInput{
+				Bucket:   &u.Bucket,
+				Key:      &dst,
+				UploadId: mpu.UploadId,
+			})
+			return err
+		}
+		parts = append(parts, s3types.CompletedPart{
+			ETag:       out.CopyPartResult.ETag,
+			PartNumber: num,
+		})
+	}
+
+	_, err = u.completeMultipartUpload(&s3.CompleteMultipartUploadInput{
+		Bucket:          &u.Bucket,
+		Key:             &dst,
+		UploadId:        mpu.UploadId,
+		MultipartUpload: &s3types.CompletedMultipartUpload{Parts: parts},
+	})
+	return err
+}
+
+// byteCounter counts the bytes written to it.
+type byteCounter int64
+
+func (c *byteCounter) Write(p []byte) (int, error) {
+	*c += byteCounter(len(p))
+	return len(p), nil
+}
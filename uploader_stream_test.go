@@ -0,0 +1,172 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	s3manager "github.com/aws/aws-sdk-go-v2/feature/s3/manager"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	s3types "github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"gotest.tools/v3/assert"
+)
+
+func newStreamRun(t *testing.T) (*testRun, *[]string) {
+	r := newTestRun(t)
+	r.Uploader.SinglePass = true
+	r.Uploader.UploadFile = nil
+	var bodies []string
+	r.Uploader.PutObject = func(
+		in *s3.PutObjectInput,
+	) (*s3manager.UploadOutput, error) {
+		r.PutObjectCalls = append(r.PutObjectCalls, in)
+		b, err := io.ReadAll(in.Body)
+		bodies = append(bodies, string(b))
+		return &s3manager.UploadOutput{}, err
+	}
+	r.Uploader.Client = new(s3Client)
+	r.Uploader.Client._DeleteObject_Return(&s3.DeleteObjectOutput{}, nil)
+	return r, &bodies
+}
+
+func TestUploadStream(t *testing.T) {
+	r, bodies := newStreamRun(t)
+	r.Uploader.Client._CopyObject_Return(&s3.CopyObjectOutput{}, nil)
+
+	url, err := r.Uploader.uploadFile("somefile")
+
+	assert.NilError(t, err)
+	key := mockFileDataEncoded + "/somefile"
+	assert.Equal(t, url, "https://somebucket.s3.amazonaws.com/"+key)
+	assert.Equal(t, len(r.PutObjectCalls), 1)
+	tmp := *r.PutObjectCalls[0].Key
+	assert.Assert(t, strings.HasPrefix(tmp, tempPrefix))
+	assert.Equal(t, r.PutObjectCalls[0].ACL, s3types.ObjectCannedACL(""))
+	assert.DeepEqual(t, *bodies, []string{string(mockFileData)})
+
+	copies := r.Uploader.Client._CopyObject_Calls()
+	assert.Equal(t, len(copies), 1)
+	assert.Equal(t, *copies[0].Params.Key, key)
+	assert.Equal(t, *copies[0].Params.CopySource, "somebucket/"+tmp)
+	assert.Equal(t, copies[0].Params.ACL, s3types.ObjectCannedACLPublicRead)
+
+	deletes := r.Uploader.Client._DeleteObject_Calls()
+	assert.Equal(t, len(deletes), 1)
+	assert.Equal(t, *deletes[0].Params.Key, tmp)
+	assert.Assert(t, r.MockFileClosed)
+}
+
+func TestUploadStreamStdin(t *testing.T) {
+	r, bodies := newStreamRun(t)
+	r.Uploader.Prefix = "shares"
+	r.Uploader.Stdin = bytes.NewReader(mockFileData)
+	r.Uploader.Client._CopyObject_Return(&s3.CopyObjectOutput{}, nil)
+
+	_, err := r.Uploader.uploadFile("-")
+
+	assert.NilError(t, err)
+	assert.DeepEqual(t, *bodies, []string{string(mockFileData)})
+	assert.Assert(t, strings.HasPrefix(
+		*r.PutObjectCalls[0].Key, "shares/"+tempPrefix,
+	))
+	copies := r.Uploader.Client._CopyObject_Calls()
+	assert.Equal(t, *copies[0].Params.Key,
+		"shares/"+mockFileDataEncoded+"/stdin")
+}
+
+func TestUploadStreamExisting(t *testing.T) {
+	r, _ := newStreamRun(t)
+	r.Uploader.ObjectExists = func(string) (bool, error) { return true, nil }
+
+	_, err := r.Uploader.uploadFile("somefile")
+
+	assert.NilError(t, err)
+	assert.Equal(t, len(r.Uploader.Client._CopyObject_Calls()), 0)
+	assert.DeepEqual(t, r.RefreshExpiryCalls, []string{
+		mockFileDataEncoded + "/somefile",
+	})
+	assert.Equal(t, len(r.Uploader.Client._DeleteObject_Calls()), 1)
+}
+
+func TestUploadStreamCopyFails(t *testing.T) {
+	r, _ := newStreamRun(t)
+	r.Uploader.Client._CopyObject_Return(nil, io.ErrUnexpectedEOF)
+
+	_, err := r.Uploader.uploadFile("somefile")
+
+	assert.ErrorIs(t, err, io.ErrUnexpectedEOF)
+	assert.Equal(t, len(r.Uploader.Client._DeleteObject_Calls()), 1)
+}
+
+func TestCopyParts(t *testing.T) {
+	r, _ := newStreamRun(t)
+	c := r.Uploader.Client
+	c._HeadObject_Return(&s3.HeadObjectOutput{
+		ContentType: aws.String("application/gzip"),
+		Metadata:    map[string]string{expiresMeta: "2024-01-01T00:00:00Z"},
+	}, nil)
+	c._CreateMultipartUpload_Return(&s3.CreateMultipartUploadOutput{
+		UploadId: aws.String("upload"),
+	}, nil)
+	c._UploadPartCopy_Do(func(
+		_ context.Context,
+		in *s3.UploadPartCopyInput,
+		_ ...func(*s3.Options),
+	) (*s3.UploadPartCopyOutput, error) {
+		return &s3.UploadPartCopyOutput{
+			CopyPartResult: &s3types.CopyPartResult{ETag: in.CopySourceRange},
+		}, nil
+	})
+	c._CompleteMultipartUpload_Return(
+		&s3.CompleteMultipartUploadOutput{}, nil,
+	)
+	size := int64(maxCopySize + 1)
+
+	err := r.Uploader.copyTo("tmp/x", "hash/big", size)
+
+	assert.NilError(t, err)
+	assert.Equal(t, len(c._CopyObject_Calls()), 0)
+	create := c._CreateMultipartUpload_Calls()[0].Params
+	assert.Equal(t, *create.ContentType, "application/gzip")
+	assert.Equal(t, create.Metadata[expiresMeta], "2024-01-01T00:00:00Z")
+	parts := c._CompleteMultipartUpload_Calls()[0].Params.
+		MultipartUpload.Parts
+	assert.Equal(t, len(parts), 11)
+	assert.Equal(t, *parts[0].ETag, "bytes=0-536870911")
+	assert.Equal(t, *parts[10].ETag, "bytes=5368709120-5368709120")
+	assert.Equal(t, *parts[10].PartNumber, int32(11))
+}
+
+func TestCopyPartsAborts(t *testing.T) {
+	r, _ := newStreamRun(t)
+	c := r.Uploader.Client
+	c._HeadObject_Return(&s3.HeadObjectOutput{}, nil)
+	c._CreateMultipartUpload_Return(&s3.CreateMultipartUploadOutput{
+		UploadId: aws.String("upload"),
+	}, nil)
+	c._UploadPartCopy_Return(nil, io.ErrUnexpectedEOF)
+	c._AbortMultipartUpload_Return(&s3.AbortMultipartUploadOutput{}, nil)
+
+	err := r.Uploader.copyTo("tmp/x", "hash/big", maxCopySize+1)
+
+	assert.ErrorIs(t, err, io.ErrUnexpectedEOF)
+	abort := c._AbortMultipartUpload_Calls()
+	assert.Equal(t, len(abort), 1)
+	assert.Equal(t, *abort[0].Params.UploadId, "upload")
+}
+
+func TestRunSinglePassDryRun(t *testing.T) {
+	r, _ := newStreamRun(t)
+	r.Uploader.Args = &[]string{
+		"s3share", "--single-pass", "--dry-run", "somefile",
+	}
+
+	err := run(r.Uploader)
+
+	assert.NilError(t, err)
+	assert.Equal(t, len(r.PutObjectCalls), 0)
+	assert.Equal(t, len(r.Uploader.Client._CopyObject_Calls()), 0)
+}
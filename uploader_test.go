@@ -1,12 +1,18 @@
 package main
 
 import (
+	"bytes"
 	"context"
 	"errors"
+	"fmt"
 	"io"
 	"io/fs"
+	"os"
+	"reflect"
 	"testing"
+	"time"
 
+	v4 "github.com/aws/aws-sdk-go-v2/aws/signer/v4"
 	s3manager "github.com/aws/aws-sdk-go-v2/feature/s3/manager"
 	"github.com/aws/aws-sdk-go-v2/service/s3"
 	s3types "github.com/aws/aws-sdk-go-v2/service/s3/types"
@@ -29,7 +35,7 @@ func TestRunNoEnv(t *testing.T) {
 
 	err := run(r.Uploader)
 
-	assert.ErrorIs(t, err, errEnvNotSet)
+	assert.ErrorIs(t, err, errNoBucket)
 }
 
 func TestRunSetupClientError(t *testing.T) {
@@ -249,3 +255,316 @@ func TestObjectExistsUnexpectedError(t *testing.T) {
 	assert.Equal(t, bucket, r.Uploader.Bucket)
 	assert.Equal(t, key, "some/key")
 }
+
+func TestRunExpires(t *testing.T) {
+	r := newTestRun(t)
+	r.Uploader.Getenv = func(name string) string {
+		switch name {
+		case "S3SHARE_BUCKET":
+			return "somebucket"
+		case "S3SHARE_EXPIRES":
+			return "24h"
+		}
+		return ""
+	}
+
+	err := run(r.Uploader)
+
+	assert.NilError(t, err)
+	assert.Equal(t, r.Uploader.Expires, 24*time.Hour)
+}
+
+func TestRunBadExpires(t *testing.T) {
+	for _, v := range []string{"tomorrow", "500ms", "-1h", "169h"} {
+		t.Run(v, func(t *testing.T) {
+			r := newTestRun(t)
+			r.Uploader.Getenv = func(name string) string {
+				switch name {
+				case "S3SHARE_BUCKET":
+					return "somebucket"
+				case "S3SHARE_EXPIRES":
+					return v
+				}
+				return ""
+			}
+
+			err := run(r.Uploader)
+
+			assert.ErrorIs(t, err, errBadExpires)
+		})
+	}
+}
+
+func TestUploadFilePresigned(t *testing.T) {
+	r := newTestRun(t)
+	r.Uploader.Expires = time.Hour
+	var presigned []*s3.GetObjectInput
+	r.Uploader.PresignGetObject = func(
+		in *s3.GetObjectInput,
+	) (*v4.PresignedHTTPRequest, error) {
+		presigned = append(presigned, in)
+		return &v4.PresignedHTTPRequest{URL: "https://signed/" + *in.Key}, nil
+	}
+
+	r.Uploader.UploadFile = nil
+	url, err := r.Uploader.uploadFile("somefile")
+
+	assert.NilError(t, err)
+	assert.Equal(t, url, "https://signed/"+mockFileDataEncoded+"/somefile")
+	assert.Equal(t, len(presigned), 1)
+	assert.Equal(t, *presigned[0].Bucket, "somebucket")
+	assert.Equal(t, len(r.PutObjectCalls), 1)
+	assert.Equal(t, r.PutObjectCalls[0].ACL, s3types.ObjectCannedACL(""))
+}
+
+func TestUploadFilePresignedObjectExists(t *testing.T) {
+	r := newTestRun(t)
+	r.Uploader.Expires = time.Hour
+	r.Uploader.ObjectExists = func(string) (bool, error) {
+		return true, nil
+	}
+	r.Uploader.PresignGetObject = func(
+		in *s3.GetObjectInput,
+	) (*v4.PresignedHTTPRequest, error) {
+		return &v4.PresignedHTTPRequest{URL: "https://signed/" + *in.Key}, nil
+	}
+
+	r.Uploader.UploadFile = nil
+	url, err := r.Uploader.uploadFile("somefile")
+
+	assert.NilError(t, err)
+	assert.Equal(t, url, "https://signed/"+mockFileDataEncoded+"/somefile")
+	assert.Equal(t, len(r.PutObjectCalls), 0)
+}
+
+func TestUploadFilePresignError(t *testing.T) {
+	r := newTestRun(t)
+	r.Uploader.Expires = time.Hour
+	presignErr := errors.New("mock error")
+	r.Uploader.PresignGetObject = func(
+		*s3.GetObjectInput,
+	) (*v4.PresignedHTTPRequest, error) {
+		return nil, presignErr
+	}
+
+	r.Uploader.UploadFile = nil
+	_, err := r.Uploader.uploadFile("somefile")
+
+	assert.ErrorIs(t, err, presignErr)
+}
+
+func TestUploadFilePrefix(t *testing.T) {
+	r := newTestRun(t)
+	r.Uploader.Prefix = "/shares/"
+
+	r.Uploader.UploadFile = nil
+	url, err := r.Uploader.uploadFile("somefile")
+
+	assert.NilError(t, err)
+	assert.Equal(t, url, "https://somebucket.s3.amazonaws.com/shares/"+
+		mockFileDataEncoded+"/somefile")
+	assert.Equal(t, *r.PutObjectCalls[0].Key,
+		"shares/"+mockFileDataEncoded+"/somefile")
+}
+
+func TestUploadFileACL(t *testing.T) {
+	tests := []struct {
+		acl     string
+		expires time.Duration
+		want    s3types.ObjectCannedACL
+	}{
+		{"", 0, s3types.ObjectCannedACLPublicRead},
+		{"", time.Hour, ""},
+		{"none", 0, ""},
+		{"private", 0, s3types.ObjectCannedACLPrivate},
+		{"public-read", time.Hour, s3types.ObjectCannedACLPublicRead},
+	}
+	for _, tt := range tests {
+		t.Run(tt.acl+"/"+tt.expires.String(), func(t *testing.T) {
+			r := newTestRun(t)
+			r.Uploader.ACL = tt.acl
+			r.Uploader.Expires = tt.expires
+			r.Uploader.PresignGetObject = func(
+				*s3.GetObjectInput,
+			) (*v4.PresignedHTTPRequest, error) {
+				return &v4.PresignedHTTPRequest{}, nil
+			}
+
+			r.Uploader.UploadFile = nil
+			_, err := r.Uploader.uploadFile("somefile")
+
+			assert.NilError(t, err)
+			assert.Equal(t, r.PutObjectCalls[0].ACL, tt.want)
+		})
+	}
+}
+
+func TestUploadFileStdin(t *testing.T) {
+	r := newTestRun(t)
+	dir := t.TempDir()
+	r.Uploader.Stdin = bytes.NewReader(mockFileData)
+	r.Uploader.CreateTemp = func() (*os.File, error) {
+		return os.CreateTemp(dir, "stdin")
+	}
+	var body []byte
+	r.Uploader.PutObject = func(
+		in *s3.PutObjectInput,
+	) (*s3manager.UploadOutput, error) {
+		r.PutObjectCalls = append(r.PutObjectCalls, in)
+		var err error
+		body, err = io.ReadAll(in.Body)
+		return &s3manager.UploadOutput{}, err
+	}
+
+	r.Uploader.UploadFile = nil
+	url, err := r.Uploader.uploadFile("-")
+
+	assert.NilError(t, err)
+	assert.Equal(t, url, "https://somebucket.s3.amazonaws.com/"+
+		mockFileDataEncoded+"/stdin")
+	assert.DeepEqual(t, body, mockFileData)
+	entries, err := os.ReadDir(dir)
+	assert.NilError(t, err)
+	assert.Equal(t, len(entries), 0)
+}
+
+func TestRunStdinName(t *testing.T) {
+	r := newTestRun(t)
+	r.Uploader.Args = &[]string{"s3share", "-", "--name", "build.log"}
+	r.Uploader.Stdin = bytes.NewReader(mockFileData)
+	r.Uploader.CreateTemp = func() (*os.File, error) {
+		return os.CreateTemp(t.TempDir(), "stdin")
+	}
+
+	r.Uploader.UploadFile = nil
+	err := run(r.Uploader)
+
+	assert.NilError(t, err)
+	assert.Equal(t, len(r.PutObjectCalls), 1)
+	assert.Equal(t, *r.PutObjectCalls[0].Key,
+		mockFileDataEncoded+"/build.log")
+}
+
+func TestUploadFileStdinTempError(t *testing.T) {
+	r := newTestRun(t)
+	tempErr := errors.New("mock error")
+	r.Uploader.CreateTemp = func() (*os.File, error) {
+		return nil, tempErr
+	}
+
+	r.Uploader.UploadFile = nil
+	_, err := r.Uploader.uploadFile("-")
+
+	assert.ErrorIs(t, err, tempErr)
+}
+
+func TestUploadFileName(t *testing.T) {
+	r := newTestRun(t)
+	r.Uploader.Name = "renamed.txt"
+
+	r.Uploader.UploadFile = nil
+	_, err := r.Uploader.uploadFile("dir/somefile")
+
+	assert.NilError(t, err)
+	assert.Equal(t, *r.PutObjectCalls[0].Key,
+		mockFileDataEncoded+"/renamed.txt")
+}
+
+func TestUploadFileBaseURL(t *testing.T) {
+	for _, exists := range []bool{true, false} {
+		t.Run(fmt.Sprint(exists), func(t *testing.T) {
+			r := newTestRun(t)
+			r.Uploader.BaseURL = "https://files.example.com"
+			r.Uploader.ObjectExists = func(string) (bool, error) {
+				return exists, nil
+			}
+
+			r.Uploader.UploadFile = nil
+			url, err := r.Uploader.uploadFile("somefile")
+
+			assert.NilError(t, err)
+			assert.Equal(t, url, "https://files.example.com/"+
+				mockFileDataEncoded+"/somefile")
+			if !exists {
+				assert.Equal(t, r.PutObjectCalls[0].ACL,
+					s3types.ObjectCannedACL(""))
+			}
+		})
+	}
+}
+
+func TestRunPutDryRun(t *testing.T) {
+	r := newTestRun(t)
+	r.Uploader.Args = &[]string{"s3share", "--dry-run", "somefile"}
+	r.Uploader.UploadFile = nil
+
+	err := run(r.Uploader)
+
+	assert.NilError(t, err)
+	assert.Equal(t, len(r.PutObjectCalls), 0)
+	assert.DeepEqual(t, r.ObjectExistsCalls, []string{
+		mockFileDataEncoded + "/somefile",
+	})
+	assert.DeepEqual(t, r.Stdout, []string{
+		"https://somebucket.s3.amazonaws.com/" +
+			mockFileDataEncoded + "/somefile",
+	})
+	assert.DeepEqual(t, r.Stderr, []string{
+		"would upload " + mockFileDataEncoded + "/somefile",
+	})
+}
+
+func TestPutDryRunExisting(t *testing.T) {
+	r := newTestRun(t)
+	r.Uploader.DryRun = true
+	r.Uploader.UploadFile = nil
+	r.Uploader.ObjectExists = func(string) (bool, error) { return true, nil }
+
+	_, err := r.Uploader.uploadFile("somefile")
+
+	assert.NilError(t, err)
+	assert.Equal(t, len(r.PutObjectCalls), 0)
+	assert.Equal(t, len(r.Stderr), 0)
+}
+
+// TestCloneCopiesEveryField sets every field of an Uploader so that a field
+// added without being copied by Clone is caught.
+func TestCloneCopiesEveryField(t *testing.T) {
+	t.Parallel()
+	u := &Uploader{
+		Context: context.Background(),
+		Since:   time.Now(),
+		Stdin:   bytes.NewReader(nil),
+		Stdout:  io.Discard,
+	}
+	v := reflect.ValueOf(u).Elem()
+	for i := 0; i < v.NumField(); i++ {
+		f := v.Field(i)
+		switch {
+		case !f.IsZero():
+		case f.Kind() == reflect.Func:
+			f.Set(reflect.MakeFunc(f.Type(), nil))
+		case f.Kind() == reflect.Pointer:
+			f.Set(reflect.New(f.Type().Elem()))
+		case f.Kind() == reflect.Slice:
+			f.Set(reflect.MakeSlice(f.Type(), 1, 1))
+		case f.Kind() == reflect.String:
+			f.SetString("x")
+		case f.Kind() == reflect.Bool:
+			f.SetBool(true)
+		case f.CanInt():
+			f.SetInt(1)
+		default:
+			t.Fatalf("cannot set %s", v.Type().Field(i).Name)
+		}
+	}
+
+	cl := reflect.ValueOf(u.Clone()).Elem()
+
+	for i := 0; i < cl.NumField(); i++ {
+		if cl.Field(i).IsZero() {
+			t.Errorf("Clone does not copy %s", cl.Type().Field(i).Name)
+		}
+	}
+	assert.Assert(t, u.Clone().Args != u.Args)
+}
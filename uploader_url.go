@@ -0,0 +1,190 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+func (u *Uploader) objectUrl(key string) (string, error) {
+	if u.BaseURL != "" {
+		if u.CFKey != nil && !u.CFCookies {
+			return u.cloudfrontUrl(u.baseUrl(key))
+		}
+		return u.baseUrl(key), nil
+	} else if !u.presigned() {
+		return u.publicUrl(key), nil
+	}
+
+	// Objects encrypted with a customer key can only be fetched by sending
+	// the key along, so the signature covers those headers too.
+	in := &s3.GetObjectInput{
+		Bucket: &u.Bucket,
+		Key:    &key,
+	}
+	in.SSECustomerAlgorithm, in.SSECustomerKey, in.SSECustomerKeyMD5 = u.ssec()
+	req, err := u.presignGetObject(in)
+	if err != nil {
+		return "", fmt.Errorf("error presigning url: %w", err)
+	}
+	return req.URL, nil
+}
+
+// urlKey returns the key that link, a URL returned by objectUrl, points to.
+// Query strings, such as the signature of a presigned link, are ignored.
+func (u *Uploader) urlKey(link string) (string, error) {
+	ref, err := url.Parse(link)
+	if err != nil {
+		return "", fmt.Errorf("%w: %s", errBadURL, link)
+	}
+	ref.RawQuery, ref.Fragment = "", ""
+	bare := ref.String()
+
+	before, after := u.publicUrl(""), ""
+	if u.BaseURL != "" {
+		before = strings.TrimSuffix(u.BaseURL, "/") + "/"
+		if strings.Contains(u.BaseURL, "{key}") {
+			base := strings.ReplaceAll(u.BaseURL, "{bucket}", u.Bucket)
+			before, after, _ = strings.Cut(base, "{key}")
+			after, _, _ = strings.Cut(after, "?")
+		}
+	}
+
+	escaped, ok := strings.CutPrefix(bare, before)
+	if ok {
+		escaped, ok = strings.CutSuffix(escaped, after)
+	}
+	if !ok && u.BaseURL == "" {
+		// Presigned links may address the bucket differently from the
+		// client, depending on the SDK.
+		if strings.HasPrefix(ref.Host, u.Bucket+".") {
+			escaped, ok = strings.TrimPrefix(ref.EscapedPath(), "/"), true
+		} else {
+			escaped, ok = strings.CutPrefix(
+				ref.EscapedPath(), "/"+u.Bucket+"/",
+			)
+		}
+	}
+	if !ok || escaped == "" {
+		return "", fmt.Errorf("%w: %s", errBadURL, link)
+	}
+
+	key, err := url.PathUnescape(escaped)
+	if err != nil {
+		return "", fmt.Errorf("%w: %s", errBadURL, link)
+	}
+	return key, nil
+}
+
+// presigned reports whether links are presigned S3 URLs.
+func (u *Uploader) presigned() bool {
+	return u.Expires != 0 && u.BaseURL == ""
+}
+
+// signed reports whether each link carries its own signature, presigned by
+// S3 or signed for CloudFront, and so stops working when it expires.
+func (u *Uploader) signed() bool {
+	return u.presigned() || (u.CFKey != nil && !u.CFCookies)
+}
+
+// baseUrl returns the link to key under the configured base URL. The base
+// URL may be a template with {key} and {bucket} placeholders; otherwise the
+// key is appended to it as a path.
+func (u *Uploader) baseUrl(key string) string {
+	if !strings.Contains(u.BaseURL, "{key}") {
+		return strings.TrimSuffix(u.BaseURL, "/") + "/" + escapeKey(key)
+	}
+	return strings.NewReplacer(
+		"{key}", escapeKey(key),
+		"{bucket}", u.Bucket,
+	).Replace(u.BaseURL)
+}
+
+// publicUrl returns the unsigned URL of key, addressed the same way as the
+// client. The bucket goes in the path rather than the host name when
+// path-style addressing is on, or when its name has dots that would not
+// match the wildcard certificate of an HTTPS endpoint.
+func (u *Uploader) publicUrl(key string) string {
+	base := u.serviceUrl()
+	host, path := base.Host, "/"+escapeKey(key)
+	if u.PathStyle ||
+		(base.Scheme == "https" && strings.Contains(u.Bucket, ".")) {
+		path = "/" + u.Bucket + path
+	} else {
+		host = u.Bucket + "." + host
+	}
+	return base.Scheme + "://" + host + strings.TrimSuffix(base.Path, "/") +
+		path
+}
+
+// serviceUrl returns the base URL of the S3 service: the custom endpoint if
+// one is set, or else the endpoint of the bucket's region.
+func (u *Uploader) serviceUrl() *url.URL {
+	if u.Endpoint != "" {
+		if ep, err := parseEndpoint(u.Endpoint); err == nil {
+			return ep
+		}
+	}
+
+	region := u.Region
+	if region == "" {
+		region = defaultRegion
+	}
+	host := "s3"
+	if u.FIPS {
+		host += "-fips"
+	}
+	if u.DualStack {
+		host += ".dualstack"
+	}
+	if host == "s3" && region == defaultRegion {
+		// The legacy global endpoint is the canonical us-east-1 host.
+		host += ".amazonaws.com"
+	} else {
+		host += "." + region + ".amazonaws.com"
+		if strings.HasPrefix(region, "cn-") {
+			host += ".cn"
+		}
+	}
+	return &url.URL{Scheme: "https", Host: host}
+}
+
+// escapeKey percent-encodes every byte of each segment of key except the
+// unreserved characters, as S3 does when signing requests. Slashes are
+// kept so the key still reads as a path.
+func escapeKey(key string) string {
+	var b strings.Builder
+	for i := 0; i < len(key); i++ {
+		switch c := key[i]; {
+		case 'a' <= c && c <= 'z', 'A' <= c && c <= 'Z', '0' <= c && c <= '9',
+			c == '-', c == '_', c == '.', c == '~', c == '/':
+			b.WriteByte(c)
+		default:
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
+
+func checkBaseURL(base string) error {
+	if _, err := parseEndpoint(strings.NewReplacer(
+		"{key}", "key",
+		"{bucket}", "bucket",
+	).Replace(base)); err != nil {
+		return fmt.Errorf("%w: %s", errBadBaseURL, base)
+	}
+	return nil
+}
+
+func parseEndpoint(endpoint string) (*url.URL, error) {
+	ep, err := url.Parse(endpoint)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", errBadEndpoint, err)
+	}
+	if (ep.Scheme != "http" && ep.Scheme != "https") || ep.Host == "" {
+		return nil, fmt.Errorf("%w: %s", errBadEndpoint, endpoint)
+	}
+	return ep, nil
+}
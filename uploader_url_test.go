@@ -0,0 +1,309 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	s3types "github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"gotest.tools/v3/assert"
+)
+
+func TestPublicUrl(t *testing.T) {
+	tests := []struct {
+		name      string
+		bucket    string
+		key       string
+		region    string
+		endpoint  string
+		pathStyle bool
+		dualStack bool
+		fips      bool
+		want      string
+	}{{
+		name: "aws",
+		want: "https://somebucket.s3.amazonaws.com/some/key",
+	}, {
+		name:      "aws path style",
+		pathStyle: true,
+		want:      "https://s3.amazonaws.com/somebucket/some/key",
+	}, {
+		name:     "endpoint",
+		endpoint: "https://r2.example.com",
+		want:     "https://somebucket.r2.example.com/some/key",
+	}, {
+		name:      "endpoint path style",
+		endpoint:  "http://minio.local:9000/",
+		pathStyle: true,
+		want:      "http://minio.local:9000/somebucket/some/key",
+	}, {
+		name:      "endpoint with path",
+		endpoint:  "https://ceph.example.com/s3/",
+		pathStyle: true,
+		want:      "https://ceph.example.com/s3/somebucket/some/key",
+	}, {
+		name:   "region",
+		region: "eu-west-1",
+		want:   "https://somebucket.s3.eu-west-1.amazonaws.com/some/key",
+	}, {
+		name:   "china region",
+		region: "cn-north-1",
+		want:   "https://somebucket.s3.cn-north-1.amazonaws.com.cn/some/key",
+	}, {
+		name:      "dualstack",
+		dualStack: true,
+		want: "https://somebucket.s3.dualstack.us-east-1.amazonaws.com/" +
+			"some/key",
+	}, {
+		name:   "fips",
+		region: "us-gov-west-1",
+		fips:   true,
+		want: "https://somebucket.s3-fips.us-gov-west-1.amazonaws.com/" +
+			"some/key",
+	}, {
+		name:      "fips dualstack path style",
+		region:    "us-east-2",
+		pathStyle: true,
+		dualStack: true,
+		fips:      true,
+		want: "https://s3-fips.dualstack.us-east-2.amazonaws.com/" +
+			"somebucket/some/key",
+	}, {
+		name:   "dotted bucket",
+		bucket: "files.example.com",
+		region: "us-west-2",
+		want: "https://s3.us-west-2.amazonaws.com/files.example.com/" +
+			"some/key",
+	}, {
+		name:     "dotted bucket over http",
+		bucket:   "files.example.com",
+		endpoint: "http://minio.local",
+		want:     "http://files.example.com.minio.local/some/key",
+	}, {
+		name: "escaped key",
+		key:  "hash/file with spaces+plus&ü.txt",
+		want: "https://somebucket.s3.amazonaws.com/" +
+			"hash/file%20with%20spaces%2Bplus%26%C3%BC.txt",
+	}}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := newTestRun(t)
+			if tt.bucket != "" {
+				r.Uploader.Bucket = tt.bucket
+			}
+			if tt.key == "" {
+				tt.key = "some/key"
+			}
+			r.Uploader.Region = tt.region
+			r.Uploader.Endpoint = tt.endpoint
+			r.Uploader.PathStyle = tt.pathStyle
+			r.Uploader.DualStack = tt.dualStack
+			r.Uploader.FIPS = tt.fips
+
+			assert.Equal(t, r.Uploader.publicUrl(tt.key), tt.want)
+		})
+	}
+}
+
+func TestSetupClientEndpoint(t *testing.T) {
+	r := newTestRun(t)
+	r.Uploader.SetupClient = nil
+	r.Uploader.Region = "us-west-2"
+	r.Uploader.Endpoint = "http://minio.local:9000"
+	r.Uploader.PathStyle = true
+
+	err := r.Uploader.setupClient()
+
+	assert.NilError(t, err)
+	opts := r.Uploader.Client.Options()
+	assert.Equal(t, *opts.BaseEndpoint, "http://minio.local:9000")
+	assert.Equal(t, opts.UsePathStyle, true)
+	assert.Equal(t, opts.Region, "us-west-2")
+}
+
+func TestSetupClientDualStackFIPS(t *testing.T) {
+	r := newTestRun(t)
+	r.Uploader.SetupClient = nil
+	r.Uploader.Region = "us-east-2"
+	r.Uploader.DualStack = true
+	r.Uploader.FIPS = true
+
+	err := r.Uploader.setupClient()
+
+	assert.NilError(t, err)
+	opts := r.Uploader.Client.Options()
+	assert.Equal(t, opts.EndpointOptions.UseDualStackEndpoint,
+		aws.DualStackEndpointStateEnabled)
+	assert.Equal(t, opts.EndpointOptions.UseFIPSEndpoint,
+		aws.FIPSEndpointStateEnabled)
+}
+
+func TestBucketRegionLocation(t *testing.T) {
+	tests := map[string]string{
+		"":             "us-east-1",
+		"EU":           "eu-west-1",
+		"eu-central-1": "eu-central-1",
+	}
+	for loc, want := range tests {
+		t.Run(want, func(t *testing.T) {
+			r := newTestRun(t)
+			r.Uploader.Client = new(s3Client)
+			var bucket string
+			r.Uploader.Client._GetBucketLocation_Do(func(
+				_ context.Context,
+				in *s3.GetBucketLocationInput,
+				_ ...func(*s3.Options),
+			) (*s3.GetBucketLocationOutput, error) {
+				bucket = *in.Bucket
+				return &s3.GetBucketLocationOutput{
+					LocationConstraint: s3types.BucketLocationConstraint(loc),
+				}, nil
+			})
+
+			region, err := r.Uploader.bucketRegion()
+
+			assert.NilError(t, err)
+			assert.Equal(t, region, want)
+			assert.Equal(t, bucket, "somebucket")
+		})
+	}
+}
+
+func TestBucketRegionHeadFallback(t *testing.T) {
+	r := newTestRun(t)
+	srv := httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, req *http.Request) {
+			assert.Equal(t, req.Method, http.MethodHead)
+			w.Header().Set("X-Amz-Bucket-Region", "ap-southeast-2")
+			w.WriteHeader(http.StatusMovedPermanently)
+		},
+	))
+	defer srv.Close()
+	r.Uploader.Client = &s3Client{Client: s3.New(s3.Options{
+		BaseEndpoint: aws.String(srv.URL),
+		HTTPClient:   srv.Client(),
+		Region:       "us-east-1",
+		UsePathStyle: true,
+	})}
+	r.Uploader.Client._GetBucketLocation_Return(
+		nil, errors.New("access denied"),
+	)
+
+	region, err := r.Uploader.bucketRegion()
+
+	assert.NilError(t, err)
+	assert.Equal(t, region, "ap-southeast-2")
+}
+
+func TestBucketRegionError(t *testing.T) {
+	r := newTestRun(t)
+	r.Uploader.Client = new(s3Client)
+	locErr := errors.New("access denied")
+	r.Uploader.Client._GetBucketLocation_Return(nil, locErr)
+	r.Uploader.Client._HeadBucket_Return(nil, errors.New("forbidden"))
+
+	_, err := r.Uploader.bucketRegion()
+
+	assert.ErrorIs(t, err, locErr)
+}
+
+func TestBaseUrl(t *testing.T) {
+	tests := []struct {
+		base string
+		want string
+	}{
+		{"https://files.example.com", "https://files.example.com/a/b%20c"},
+		{"https://files.example.com/", "https://files.example.com/a/b%20c"},
+		{"https://cdn.example.com/s/", "https://cdn.example.com/s/a/b%20c"},
+		{
+			"https://cdn.example.com/{key}?from={bucket}",
+			"https://cdn.example.com/a/b%20c?from=somebucket",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.base, func(t *testing.T) {
+			r := newTestRun(t)
+			r.Uploader.BaseURL = tt.base
+
+			url, err := r.Uploader.objectUrl("a/b c")
+
+			assert.NilError(t, err)
+			assert.Equal(t, url, tt.want)
+		})
+	}
+}
+
+func TestBaseUrlOverridesPresign(t *testing.T) {
+	r := newTestRun(t)
+	r.Uploader.BaseURL = "https://files.example.com"
+	r.Uploader.Expires = time.Hour
+
+	url, err := r.Uploader.objectUrl("a/b")
+
+	assert.NilError(t, err)
+	assert.Equal(t, url, "https://files.example.com/a/b")
+}
+
+func TestCheckBaseURL(t *testing.T) {
+	assert.NilError(t, checkBaseURL("https://cdn.example.com/{key}"))
+	assert.ErrorIs(t, checkBaseURL("cdn.example.com"), errBadBaseURL)
+	assert.ErrorIs(t, checkBaseURL("{key}"), errBadBaseURL)
+}
+
+func TestUrlKey(t *testing.T) {
+	tests := []struct {
+		name      string
+		base      string
+		endpoint  string
+		pathStyle bool
+		link      string
+	}{
+		{name: "public"},
+		{name: "path style", pathStyle: true},
+		{name: "endpoint", endpoint: "http://minio.local:9000/s3"},
+		{name: "base", base: "https://cdn.example.com/s"},
+		{name: "template", base: "https://cdn.example.com/{key}?b={bucket}"},
+		{
+			name: "presigned",
+			link: "https://s3.amazonaws.com/somebucket/a/b%20c%2B.txt" +
+				"?X-Amz-Signature=abc",
+		},
+		{
+			name: "signed",
+			base: "https://cdn.example.com",
+			link: "https://cdn.example.com/a/b%20c%2B.txt?Expires=1" +
+				"&Signature=x&Key-Pair-Id=K",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := newTestRun(t)
+			r.Uploader.BaseURL = tt.base
+			r.Uploader.Endpoint = tt.endpoint
+			r.Uploader.PathStyle = tt.pathStyle
+			if tt.link == "" {
+				var err error
+				tt.link, err = r.Uploader.objectUrl("a/b c+.txt")
+				assert.NilError(t, err)
+			}
+
+			key, err := r.Uploader.urlKey(tt.link)
+
+			assert.NilError(t, err)
+			assert.Equal(t, key, "a/b c+.txt")
+		})
+	}
+}
+
+func TestUrlKeyOtherBucket(t *testing.T) {
+	r := newTestRun(t)
+
+	_, err := r.Uploader.urlKey("https://other.s3.amazonaws.com/a/b")
+
+	assert.ErrorIs(t, err, errBadURL)
+}